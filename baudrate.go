@@ -0,0 +1,104 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// x2m200SetBaudRate changes the rate of the module's serial port, the ack
+// still comes at the old rate.
+// Example: <Start> + <XTS_SPC_MOD_SETBAUDRATE> + [Baudrate(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
+const x2m200SetBaudRate = 0x80
+
+// baudRater is a port whose baud rate can be changed, SerialPort and
+// RFC2217Port are.
+type baudRater interface {
+	SetBaudRate(baud int) error
+	BaudRate() (int, error)
+}
+
+// portBaudRater returns the port under f if its rate can be changed.
+func portBaudRater(f Framer) (baudRater, bool) {
+	x, ok := f.(interface{ port() io.Closer })
+	if !ok {
+		return nil, false
+	}
+	p, ok := x.port().(baudRater)
+	return p, ok
+}
+
+// SetBaudRate moves the module and the port to baud, see
+// SetBaudRateContext.
+func (r *Module) SetBaudRate(baud int) error {
+	return r.SetBaudRateContext(context.Background(), baud)
+}
+
+// SetBaudRateContext tells the module to switch to baud, then switches the
+// port and pings the module to make sure both ends agree. When the module
+// does not answer at the new rate both are put back to the old one.
+func (r *Module) SetBaudRateContext(ctx context.Context, baud int) error {
+	p, ok := portBaudRater(r.f)
+	if !ok {
+		return fmt.Errorf("set baud rate %d: %w", baud, ErrBaudRateNotSupported)
+	}
+	old, err := p.BaudRate()
+	if err != nil {
+		return fmt.Errorf("set baud rate %d: %w", baud, err)
+	}
+	if old == baud {
+		return nil
+	}
+	r.d.cmd.Lock()
+	defer r.d.cmd.Unlock()
+	if err := r.sendBaudRate(ctx, baud, r.Retries); err != nil {
+		return fmt.Errorf("set baud rate %d: %w", baud, err)
+	}
+	if err := p.SetBaudRate(baud); err != nil {
+		return fmt.Errorf("set baud rate %d: module switched but the port did not: %w", baud, err)
+	}
+	err = r.verifyBaudRate(ctx)
+	if err == nil {
+		return nil
+	}
+
+	// the module either did not move or cannot be heard at the new rate,
+	// ask it back once in case it did
+	r.sendBaudRate(ctx, old, 0)
+	if perr := p.SetBaudRate(old); perr != nil {
+		return fmt.Errorf("set baud rate %d: %w, back to %d: %w", baud, err, old, perr)
+	}
+	if rerr := r.verifyBaudRate(ctx); rerr != nil {
+		return fmt.Errorf("set baud rate %d: %w, back at %d: %w", baud, err, old, rerr)
+	}
+	return fmt.Errorf("set baud rate %d: %w, back at %d", baud, err, old)
+}
+
+// sendBaudRate sends the set baud rate command.
+func (r *Module) sendBaudRate(ctx context.Context, baud, retries int) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(baud))
+	_, err := transact(ctx, r, Command[struct{}]{
+		Name:    "set baud rate",
+		Payload: []byte{x2m200SetBaudRate, b[0], b[1], b[2], b[3]},
+		Reply:   expectAck,
+		Timeout: r.Timeout,
+		Retries: retries,
+	})
+	return err
+}
+
+// verifyBaudRate pings the module, a module that is still booting
+// answers too.
+func (r *Module) verifyBaudRate(ctx context.Context) error {
+	c := pingCommand()
+	c.Timeout, c.Retries = r.Timeout, r.Retries
+	_, err := transact(ctx, r, c)
+	if errors.Is(err, ErrCommandTimeout) {
+		return fmt.Errorf("%w: %w", ErrPingTimeout, err)
+	}
+	return pingError(err)
+}
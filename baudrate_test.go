@@ -0,0 +1,144 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serialLine joins a host port and a module that only understand each
+// other at the same baud rate, bytes sent at the wrong one arrive as noise.
+type serialLine struct {
+	mu         sync.Mutex
+	host, dev  int
+	hostConn   net.Conn
+	devConn    net.Conn
+	supported  map[int]bool
+	moduleGoes bool
+}
+
+// linePort is the host side of a serialLine.
+type linePort struct {
+	net.Conn
+	l *serialLine
+}
+
+func (p linePort) SetBaudRate(baud int) error {
+	p.l.mu.Lock()
+	defer p.l.mu.Unlock()
+	p.l.host = baud
+	return nil
+}
+
+func (p linePort) BaudRate() (int, error) {
+	p.l.mu.Lock()
+	defer p.l.mu.Unlock()
+	return p.l.host, nil
+}
+
+func newSerialLine(moduleGoes bool) (*serialLine, linePort) {
+	l := &serialLine{host: DefaultBaudRate, dev: DefaultBaudRate, moduleGoes: moduleGoes}
+	host, a := net.Pipe()
+	b, dev := net.Pipe()
+	l.hostConn, l.devConn = host, dev
+	go l.carry(a, b)
+	go l.carry(b, a)
+	go l.module(dev)
+	return l, linePort{host, l}
+}
+
+func (l *serialLine) carry(from, to net.Conn) {
+	defer to.Close()
+	buf := make([]byte, 256)
+	for {
+		n, err := from.Read(buf)
+		if err != nil {
+			return
+		}
+		l.mu.Lock()
+		if l.host != l.dev {
+			for i := range buf[:n] {
+				buf[i] = 0x00
+			}
+		}
+		l.mu.Unlock()
+		if _, err := to.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// module answers pings and set baud rate commands, it moves to the new rate
+// after the ack when moduleGoes is set.
+func (l *serialLine) module(conn net.Conn) {
+	d := NewFrameDecoder(conn)
+	for {
+		p, err := d.Decode()
+		if err != nil {
+			if isFramingError(err) {
+				continue
+			}
+			return
+		}
+		switch p[0] {
+		case x2m200PingCommand:
+			conn.Write(AppendFrame(nil, pingResponse(x2m200PingResponseReady)))
+		case x2m200SetBaudRate:
+			baud := int(binary.LittleEndian.Uint32(p[1:]))
+			conn.Write(AppendFrame(nil, []byte{ack}))
+			if l.moduleGoes {
+				l.mu.Lock()
+				l.dev = baud
+				l.mu.Unlock()
+			}
+		}
+	}
+}
+
+func TestSetBaudRate(t *testing.T) {
+	cases := []struct {
+		moduleGoes bool
+		err        error
+		expected   int
+	}{
+		{true, nil, 921600},
+		// rolled back
+		{false, ErrPingTimeout, DefaultBaudRate},
+	}
+	for n, c := range cases {
+		l, port := newSerialLine(c.moduleGoes)
+		x := Open("x2m200", port)
+		m := NewModule(x, "respiration")
+		m.Timeout = 20 * time.Millisecond
+		m.Retries = 1
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := m.SetBaudRateContext(ctx, 921600)
+		if !errors.Is(err, c.err) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		l.mu.Lock()
+		if l.host != c.expected || l.dev != c.expected {
+			t.Errorf("test %d Expected: %d, got host %d module %d\n", n, c.expected, l.host, l.dev)
+		}
+		l.mu.Unlock()
+		// still talking
+		if ok, err := m.PingContext(ctx); !ok || err != nil {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, true, nil, ok, err)
+		}
+		cancel()
+		x.Close()
+		l.devConn.Close()
+	}
+
+	host, dev := net.Pipe()
+	defer dev.Close()
+	m := NewModule(Open("x2m200", host), "respiration")
+	if err := m.SetBaudRate(921600); !errors.Is(err, ErrBaudRateNotSupported) {
+		t.Errorf("Expected: %v, got %v\n", ErrBaudRateNotSupported, err)
+	}
+}
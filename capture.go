@@ -0,0 +1,286 @@
+package xethru
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Capture files hold the raw frames of a session in both directions so it
+// can be replayed later. All integers are little endian.
+//
+//	File header, 16 bytes:
+//	  [0:8]   magic "XTHRUCAP"
+//	  [8:10]  version, currently 1
+//	  [10:16] reserved, zero
+//
+//	Record header, 16 bytes, followed by Length bytes of data:
+//	  [0:8]   host time in nanoseconds since the unix epoch
+//	  [8]     kind, see the Capture* kinds
+//	  [9:12]  reserved, zero
+//	  [12:16] length of the data
+//
+// Frame records hold the frame exactly as it was on the wire, from START
+// to END including the escapes. System info records hold a SystemInfo as
+// JSON. Device records hold the device given to Open, x4m200 for instance,
+// they come first. Readers skip records of unknown kinds.
+const (
+	captureMagic      = "XTHRUCAP"
+	captureVersion    = 1
+	captureHeaderSize = 16
+	recordHeaderSize  = 16
+	// maxCaptureRecord guards against reading a corrupt length.
+	maxCaptureRecord = 1 << 24
+)
+
+// CaptureKind is the type of a capture record.
+type CaptureKind byte
+
+// Record kinds.
+const (
+	CaptureInbound    CaptureKind = 0 // frame from the module
+	CaptureOutbound   CaptureKind = 1 // frame to the module
+	CaptureSystemInfo CaptureKind = 2 // what the module said it is
+	CaptureDevice     CaptureKind = 3 // the device the Framer was opened for
+)
+
+// ErrNotCapture is returned when a file does not start with a capture header.
+var ErrNotCapture = errors.New("not a xethru capture file")
+
+// CaptureRecord is one record of a capture file.
+type CaptureRecord struct {
+	Time time.Time
+	Kind CaptureKind
+	Data []byte
+}
+
+// Direction returns the direction of a frame record.
+func (r CaptureRecord) Direction() Direction {
+	if r.Kind == CaptureOutbound {
+		return Outbound
+	}
+	return Inbound
+}
+
+// IsFrame reports whether the record holds a frame.
+func (r CaptureRecord) IsFrame() bool {
+	return r.Kind == CaptureInbound || r.Kind == CaptureOutbound
+}
+
+// SystemInfo decodes a system info record.
+func (r CaptureRecord) SystemInfo() (SystemInfo, error) {
+	var s SystemInfo
+	if r.Kind != CaptureSystemInfo {
+		return s, fmt.Errorf("capture record of kind %d holds no system info", r.Kind)
+	}
+	err := json.Unmarshal(r.Data, &s)
+	return s, err
+}
+
+func captureKind(d Direction) CaptureKind {
+	if d == Outbound {
+		return CaptureOutbound
+	}
+	return CaptureInbound
+}
+
+// CaptureWriter writes a capture file, it is safe for concurrent use.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	hdr [recordHeaderSize]byte
+}
+
+// NewCaptureWriter writes the file header to w and returns a writer for
+// the records.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	var hdr [captureHeaderSize]byte
+	copy(hdr[:], captureMagic)
+	binary.LittleEndian.PutUint16(hdr[8:10], captureVersion)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteRecord appends a record to the capture.
+func (c *CaptureWriter) WriteRecord(r CaptureRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	binary.LittleEndian.PutUint64(c.hdr[0:8], uint64(r.Time.UnixNano()))
+	c.hdr[8] = byte(r.Kind)
+	binary.LittleEndian.PutUint32(c.hdr[12:16], uint32(len(r.Data)))
+	if _, err := c.w.Write(c.hdr[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(r.Data)
+	return err
+}
+
+// WriteFrame records a raw frame seen at time t.
+func (c *CaptureWriter) WriteFrame(t time.Time, d Direction, raw []byte) error {
+	return c.WriteRecord(CaptureRecord{Time: t, Kind: captureKind(d), Data: raw})
+}
+
+// WriteSystemInfo records the identity of the module seen at time t, so
+// that the capture says where it came from.
+func (c *CaptureWriter) WriteSystemInfo(t time.Time, s SystemInfo) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return c.WriteRecord(CaptureRecord{Time: t, Kind: CaptureSystemInfo, Data: b})
+}
+
+// WriteDevice records the device given to Open, so that a replay parses
+// the frames as that module sends them. It goes before the frames.
+func (c *CaptureWriter) WriteDevice(t time.Time, device string) error {
+	return c.WriteRecord(CaptureRecord{Time: t, Kind: CaptureDevice, Data: []byte(device)})
+}
+
+// Tap returns a Tap that records the exact wire bytes of a Framer, use it
+// with WithTap after WriteDevice. Write errors are dropped.
+func (c *CaptureWriter) Tap() Tap {
+	return func(ev TapEvent) {
+		c.WriteFrame(ev.Time, ev.Direction, ev.Raw)
+	}
+}
+
+// CaptureReader reads the records of a capture file.
+type CaptureReader struct {
+	r   *bufio.Reader
+	hdr [recordHeaderSize]byte
+}
+
+// NewCaptureReader checks the file header and returns a reader for the
+// records that follow.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	var hdr [captureHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotCapture
+		}
+		return nil, err
+	}
+	if string(hdr[:8]) != captureMagic {
+		return nil, ErrNotCapture
+	}
+	if v := binary.LittleEndian.Uint16(hdr[8:10]); v != captureVersion {
+		return nil, fmt.Errorf("capture version %d not supported", v)
+	}
+	return &CaptureReader{r: br}, nil
+}
+
+// ReadRecord returns the next record, or io.EOF at the end of the capture.
+func (c *CaptureReader) ReadRecord() (CaptureRecord, error) {
+	if _, err := io.ReadFull(c.r, c.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return CaptureRecord{}, fmt.Errorf("truncated capture record: %w", err)
+		}
+		return CaptureRecord{}, err
+	}
+	n := binary.LittleEndian.Uint32(c.hdr[12:16])
+	if n > maxCaptureRecord {
+		return CaptureRecord{}, fmt.Errorf("capture record of %d bytes is too big", n)
+	}
+	rec := CaptureRecord{
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(c.hdr[0:8]))),
+		Kind: CaptureKind(c.hdr[8]),
+		Data: make([]byte, n),
+	}
+	if _, err := io.ReadFull(c.r, rec.Data); err != nil {
+		return CaptureRecord{}, fmt.Errorf("truncated capture record: %w", io.ErrUnexpectedEOF)
+	}
+	return rec, nil
+}
+
+// recorder is a Framer that writes every frame going through another Framer
+// to a capture.
+type recorder struct {
+	f Framer
+	w *CaptureWriter
+}
+
+// Record wraps f so that all of its traffic is written to w, after the
+// device f was opened for. The frames are re-encoded from their payloads,
+// use CaptureWriter.Tap on a Framer from Open to keep the exact bytes seen
+// on the wire.
+func Record(f Framer, w *CaptureWriter) Framer {
+	w.WriteDevice(time.Now(), driverOf(f).name)
+	return &recorder{f: f, w: w}
+}
+
+func (r *recorder) record(d Direction, p []byte) {
+	r.w.WriteFrame(time.Now(), d, AppendFrame(nil, p))
+}
+
+// recordSystemInfo is called by Module.SystemInfo.
+func (r *recorder) recordSystemInfo(s SystemInfo) {
+	r.w.WriteSystemInfo(time.Now(), s)
+}
+
+// recordError records the error frame behind a protocol error, which the
+// wrapped Framer does not return as a frame.
+func (r *recorder) recordError(err error) {
+	var pe *ProtocolError
+	if errors.As(err, &pe) {
+		r.record(Inbound, []byte{errorByte, pe.Code})
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	return r.WriteFrameContext(context.Background(), p)
+}
+
+func (r *recorder) WriteFrameContext(ctx context.Context, p []byte) (int, error) {
+	n, err := r.f.WriteFrameContext(ctx, p)
+	if err == nil {
+		r.record(Outbound, p)
+	}
+	return n, err
+}
+
+func (r *recorder) Read(b []byte) (int, error) {
+	return readContext(context.Background(), r, b)
+}
+
+func (r *recorder) ReadFrame() (*Frame, error) {
+	return r.ReadFrameContext(context.Background())
+}
+
+func (r *recorder) ReadFrameContext(ctx context.Context) (*Frame, error) {
+	f, err := r.f.ReadFrameContext(ctx)
+	if err != nil {
+		r.recordError(err)
+		return nil, err
+	}
+	r.record(Inbound, f.Payload)
+	return f, nil
+}
+
+func (r *recorder) driver() *driver {
+	return driverOf(r.f)
+}
+
+func (r *recorder) Stats() FrameStats {
+	return r.f.Stats()
+}
+
+func (r *recorder) Reset() (bool, error) {
+	return r.ResetContext(context.Background())
+}
+
+func (r *recorder) ResetContext(ctx context.Context) (bool, error) {
+	return reset(ctx, r)
+}
+
+func (r *recorder) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,261 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	t0 := time.Unix(1476000000, 123456789)
+	records := []CaptureRecord{
+		{Time: t0, Kind: CaptureOutbound, Data: AppendFrame(nil, []byte{resetCmd})},
+		{Time: t0.Add(time.Millisecond), Kind: CaptureInbound, Data: AppendFrame(nil, []byte{ack})},
+		{Time: t0.Add(2 * time.Millisecond), Kind: CaptureKind(0x7f), Data: []byte("future")},
+		{Time: t0.Add(3 * time.Millisecond), Kind: CaptureInbound, Data: []byte{}},
+	}
+	var b bytes.Buffer
+	w, err := NewCaptureWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewCaptureReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, c := range records {
+		got, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		if !got.Time.Equal(c.Time) || got.Kind != c.Kind || !bytes.Equal(got.Data, c.Data) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c, got)
+		}
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("Expected: %v, got %v\n", io.EOF, err)
+	}
+}
+
+func TestCaptureReaderErrors(t *testing.T) {
+	if _, err := NewCaptureReader(bytes.NewReader([]byte("not a capture file"))); err != ErrNotCapture {
+		t.Errorf("Expected: %v, got %v\n", ErrNotCapture, err)
+	}
+	var b bytes.Buffer
+	w, _ := NewCaptureWriter(&b)
+	w.WriteFrame(time.Now(), Inbound, AppendFrame(nil, respirationPayload()))
+	truncated := b.Bytes()[:b.Len()-3]
+	r, err := NewCaptureReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadRecord(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected: %v, got %v\n", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func sleepPayload() []byte {
+	b := make([]byte, sleepsize)
+	b[0] = appDataByte
+	binary.LittleEndian.PutUint32(b[1:5], uint32(sleepApp))
+	return b
+}
+
+// captureOf builds a capture holding the given inbound payloads spaced by gap.
+func captureOf(t *testing.T, gap time.Duration, payloads ...[]byte) []byte {
+	var b bytes.Buffer
+	w, err := NewCaptureWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1476000000, 0)
+	w.WriteFrame(t0, Outbound, AppendFrame(nil, []byte{0x20, 0x01}))
+	for i, p := range payloads {
+		w.WriteFrame(t0.Add(time.Duration(i)*gap), Inbound, AppendFrame(nil, p))
+	}
+	return b.Bytes()
+}
+
+func TestReplayDrivesModule(t *testing.T) {
+	capture := captureOf(t, 100*time.Millisecond, respirationPayload(), respirationPayload(), sleepPayload())
+	f, err := NewReplay(bytes.NewReader(capture), ReplayAsFastAsPossible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewModule(f, "respiration")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := make(chan interface{})
+	go m.RunContext(ctx, stream)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, ok := (<-stream).(Respiration); !ok {
+			t.Errorf("Expected: Respiration\n")
+		}
+	}
+	if _, ok := (<-stream).(Sleep); !ok {
+		t.Errorf("Expected: Sleep\n")
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("as fast as possible took %v\n", time.Since(start))
+	}
+	if st := f.Stats(); st.FramesReceived != 3 {
+		t.Errorf("Expected: %d, got %d\n", 3, st.FramesReceived)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	cases := []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{ReplayRealTime, 100 * time.Millisecond, 400 * time.Millisecond},
+		{10, 10 * time.Millisecond, 90 * time.Millisecond},
+	}
+	for n, c := range cases {
+		capture := captureOf(t, 50*time.Millisecond, respirationPayload(), respirationPayload(), respirationPayload())
+		f, _ := NewReplay(bytes.NewReader(capture), c.speed)
+		start := time.Now()
+		for {
+			fr, err := f.ReadFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			fr.Release()
+		}
+		if d := time.Since(start); d < c.min || d > c.max {
+			t.Errorf("test %d Expected: between %v and %v, got %v\n", n, c.min, c.max, d)
+		}
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	capture := captureOf(t, time.Hour, respirationPayload(), respirationPayload())
+	f, _ := NewReplay(bytes.NewReader(capture), ReplayRealTime)
+	fr, err := f.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.Release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadFrameContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	var in []byte
+	in = AppendFrame(in, []byte{ack})
+	in = AppendFrame(in, []byte{errorByte, ProtocolErrorCRCFailed})
+	in = AppendFrame(in, respirationPayload())
+	port := rwc{bytes.NewReader(in), new(bytes.Buffer)}
+
+	var capture bytes.Buffer
+	w, _ := NewCaptureWriter(&capture)
+	f := Record(Open("x2m200", port), w)
+	f.Write([]byte{resetCmd})
+	b := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		f.Read(b)
+	}
+
+	expected := []struct {
+		kind    CaptureKind
+		payload []byte
+	}{
+		{CaptureOutbound, []byte{resetCmd}},
+		{CaptureInbound, []byte{ack}},
+		{CaptureInbound, []byte{errorByte, ProtocolErrorCRCFailed}},
+		{CaptureInbound, respirationPayload()},
+	}
+	r, err := NewCaptureReader(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := r.ReadRecord(); err != nil || rec.Kind != CaptureDevice || string(rec.Data) != "x2m200" {
+		t.Fatalf("Expected: device record x2m200, got %v %q %v\n", rec.Kind, rec.Data, err)
+	}
+	for n, c := range expected {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		if rec.Kind != c.kind || !bytes.Equal(rec.Data, AppendFrame(nil, c.payload)) {
+			t.Errorf("test %d Expected: %v %x, got %v %x\n", n, c.kind, AppendFrame(nil, c.payload), rec.Kind, rec.Data)
+		}
+	}
+}
+
+func TestCaptureTapKeepsWireBytes(t *testing.T) {
+	raw := []byte{0x7d, 0x10, 0x6d, 0x7e}
+	port := rwc{bytes.NewReader(raw), new(bytes.Buffer)}
+	var capture bytes.Buffer
+	w, _ := NewCaptureWriter(&capture)
+	f := Open("x2m200", port, WithTap(w.Tap()))
+	f.Read(make([]byte, 16))
+
+	r, _ := NewCaptureReader(&capture)
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Kind != CaptureInbound || !bytes.Equal(rec.Data, raw) {
+		t.Errorf("Expected: %x, got %x\n", raw, rec.Data)
+	}
+}
+
+func TestReplayDevice(t *testing.T) {
+	presence := x4Message(presenceSingle, 1, 1, 2.5, byte(0), 8)
+	cases := []struct {
+		device   string
+		record   bool
+		expected *driver
+	}{
+		{"x4m300", true, x4m300Driver},
+		{"X4M200", true, x4m200Driver},
+		{"x4m300", false, x2m200Driver},
+	}
+	for n, c := range cases {
+		var capture bytes.Buffer
+		w, _ := NewCaptureWriter(&capture)
+		port := rwc{bytes.NewReader(AppendFrame(nil, presence)), new(bytes.Buffer)}
+		// a tap has no device record
+		var f Framer
+		if c.record {
+			f = Record(Open(c.device, port), w)
+		} else {
+			f = Open(c.device, port, WithTap(w.Tap()))
+		}
+		f.Read(make([]byte, 64))
+
+		r, err := NewReplay(&capture, ReplayAsFastAsPossible)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := driverOf(r); d != c.expected {
+			t.Errorf("test %d Expected: %s, got %s\n", n, c.expected.name, d.name)
+		}
+		fr, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		if !bytes.Equal(fr.Payload, presence) {
+			t.Errorf("test %d Expected: %x, got %x\n", n, presence, fr.Payload)
+		}
+		fr.Release()
+	}
+}
@@ -0,0 +1,59 @@
+// Command x2m200-sim emulates an X2M200 module on a pseudo terminal so the
+// driver and the tools built on it can run without the hardware.
+//
+//	x2m200-sim -link /tmp/ttyX2M200
+//
+// prints the tty of the emulated module and serves it until interrupted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/NeuralSpaz/xethru/emulator"
+)
+
+func main() {
+	var c emulator.Config
+	flag.DurationVar(&c.BootTime, "boot", 0, "time the module takes to boot after power on or a reset")
+	flag.DurationVar(&c.RespirationInterval, "respiration-interval", emulator.DefaultRespirationInterval, "time between respiration messages")
+	flag.DurationVar(&c.SleepInterval, "sleep-interval", emulator.DefaultSleepInterval, "time between sleep messages")
+	flag.DurationVar(&c.BasebandInterval, "baseband-interval", emulator.DefaultBasebandInterval, "time between baseband messages")
+	flag.IntVar(&c.Bins, "bins", emulator.DefaultBins, "range bins in a baseband message")
+	flag.Float64Var(&c.RPM, "rpm", emulator.DefaultRPM, "breaths per minute of the emulated person")
+	flag.Float64Var(&c.Distance, "distance", emulator.DefaultDistance, "distance to the emulated person in meters")
+	flag.StringVar(&c.SerialNumber, "serial", emulator.DefaultSerialNumber, "serial number in the system info")
+	link := flag.String("link", "", "also make the tty available at this path")
+	flag.Parse()
+
+	p, err := emulator.ListenPTY(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	path := p.Path()
+	if *link != "" {
+		// only replace a link left behind by an earlier run
+		if fi, err := os.Lstat(*link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			os.Remove(*link)
+		}
+		if err := os.Symlink(path, *link); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(*link)
+		path = *link
+	}
+	fmt.Println(path)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sig:
+	case <-p.Done():
+		log.Println(p.Err())
+	}
+}
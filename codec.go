@@ -0,0 +1,265 @@
+package xethru
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// The XeThru serial protocol frames every message as
+// <START> + [Data] + <CRC> + <END>. Any START, END or ESC byte that appears
+// in the data or in the CRC is sent as <ESC> + byte. The CRC is the XOR of
+// START and the unescaped data.
+
+// isFlagByte reports whether b has to be escaped on the wire.
+func isFlagByte(b byte) bool {
+	return b == startByte || b == endByte || b == escByte
+}
+
+func appendEscaped(dst []byte, b byte) []byte {
+	if isFlagByte(b) {
+		dst = append(dst, escByte)
+	}
+	return append(dst, b)
+}
+
+// AppendFrame appends the framed and escaped form of payload p to dst and
+// returns the extended buffer.
+func AppendFrame(dst, p []byte) []byte {
+	crc := byte(startByte)
+	dst = append(dst, startByte)
+	for _, b := range p {
+		crc ^= b
+		dst = appendEscaped(dst, b)
+	}
+	dst = appendEscaped(dst, crc)
+	return append(dst, endByte)
+}
+
+// FrameEncoder writes payloads as XeThru serial protocol frames.
+// Encode is safe for concurrent use, each frame is handed to the
+// underlying writer with a single Write.
+type FrameEncoder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	// tap, when set, is called with every frame after it is written
+	tap func(raw, p []byte, err error)
+}
+
+// NewFrameEncoder returns a FrameEncoder that writes frames to w.
+func NewFrameEncoder(w io.Writer) *FrameEncoder {
+	return &FrameEncoder{w: w}
+}
+
+// Encode frames p and writes it to the underlying writer. It returns the
+// number of bytes written on the wire.
+func (e *FrameEncoder) Encode(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf = AppendFrame(e.buf[:0], p)
+	n, err := e.w.Write(e.buf)
+	if e.tap != nil {
+		e.tap(e.buf, p, err)
+	}
+	return n, err
+}
+
+// FrameDecoder reads XeThru serial protocol frames, removing the escapes and
+// checking the CRC.
+type FrameDecoder struct {
+	r     *bufio.Reader
+	buf   []byte
+	stats *linkStats
+	max   int
+	// resync is set after a failed frame, whatever is left of it is
+	// skipped before the next one. esc is set when it stopped right after
+	// an ESC.
+	resync bool
+	esc    bool
+	// raw keeps the wire bytes of the last frame when keepRaw is set
+	raw     []byte
+	keepRaw bool
+}
+
+// DefaultMaxFrameSize is the largest frame accepted on the wire unless
+// told otherwise, it leaves room for a fully escaped baseband frame.
+const DefaultMaxFrameSize = 1 << 16
+
+// NewFrameDecoder returns a FrameDecoder that reads frames from r.
+func NewFrameDecoder(r io.Reader) *FrameDecoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &FrameDecoder{r: br, max: DefaultMaxFrameSize}
+}
+
+// SetMaxFrameSize sets the largest frame accepted in bytes on the wire,
+// including START, END and escapes. Longer frames are dropped with
+// ErrFrameTooLong. A size of 0 or less removes the limit.
+func (d *FrameDecoder) SetMaxFrameSize(n int) {
+	d.max = n
+}
+
+// Decode reads the next frame and returns its payload without the START,
+// CRC and END bytes. The payload is only valid until the next call to Decode.
+//
+// If the stream does not begin with START, Decode discards everything up to
+// the next START and returns ErrNoStartByte, the following call picks
+// up the frame from there. After a frame fails the next call first skips
+// to the next START, so the rest of a broken frame is never taken for the
+// start of another one.
+func (d *FrameDecoder) Decode() ([]byte, error) {
+	p, err := d.DecodeTo(d.buf)
+	if p != nil {
+		d.buf = p[:0]
+	}
+	return p, err
+}
+
+// DecodeTo is like Decode but unescapes the frame into dst[:0], growing it
+// only when the frame does not fit. The returned payload aliases dst.
+func (d *FrameDecoder) DecodeTo(dst []byte) ([]byte, error) {
+	d.raw = d.raw[:0]
+	if d.resync {
+		n, err := d.skipToStart(d.esc)
+		d.discarded(n)
+		if err != nil {
+			return nil, err
+		}
+		d.resync = false
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if c != startByte {
+		n, err := d.skipToStart(c == escByte)
+		d.discarded(n + 1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, ErrNoStartByte
+	}
+	u := unstuffer{buf: dst}
+	u.start()
+	if d.keepRaw {
+		d.raw = append(d.raw, startByte)
+	}
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		done, err := u.feed(c)
+		if u.dropped > 0 {
+			d.discarded(u.dropped)
+			u.dropped = 0
+			d.raw = d.raw[:0]
+		}
+		if d.keepRaw {
+			d.raw = append(d.raw, c)
+		}
+		if err != nil {
+			d.stats.received(u.wire, err)
+			d.resync, d.esc = true, false
+			return nil, err
+		}
+		if done {
+			p, err := u.payload()
+			d.stats.received(u.wire, err)
+			return p, err
+		}
+		if d.max > 0 && u.wire >= d.max {
+			d.stats.discarded(u.wire)
+			d.stats.tooLong()
+			d.resync, d.esc = true, u.esc
+			return nil, ErrFrameTooLong
+		}
+	}
+}
+
+// discarded records n bytes skipped to get back in step with the frames.
+func (d *FrameDecoder) discarded(n int) {
+	if n > 0 {
+		d.stats.discarded(n)
+		d.stats.resynced()
+	}
+}
+
+// skipToStart discards bytes up to but not including the next START and
+// returns how many it dropped. An escaped START is part of the frame being
+// skipped, esc tells whether the byte before the first one was an ESC.
+func (d *FrameDecoder) skipToStart(esc bool) (int, error) {
+	n := 0
+	for {
+		b, err := d.r.Peek(1)
+		if err != nil {
+			return n, err
+		}
+		if b[0] == startByte && !esc {
+			return n, nil
+		}
+		esc = !esc && b[0] == escByte
+		d.r.ReadByte()
+		n++
+	}
+}
+
+// unstuffer is the byte at a time state machine shared by FrameDecoder and
+// validator. start is called after a START byte has been seen.
+type unstuffer struct {
+	buf []byte
+	esc bool
+	// wire and dropped count the bytes since START and the bytes given up
+	// when a frame is started over.
+	wire    int
+	dropped int
+}
+
+func (u *unstuffer) start() {
+	u.buf = u.buf[:0]
+	u.esc = false
+	u.wire = 1
+}
+
+// feed consumes one byte of the frame body and reports whether END was
+// reached. An unescaped START inside a frame starts the frame over.
+func (u *unstuffer) feed(c byte) (bool, error) {
+	u.wire++
+	if u.esc {
+		u.esc = false
+		if !isFlagByte(c) {
+			return false, ErrBadEscape
+		}
+		u.buf = append(u.buf, c)
+		return false, nil
+	}
+	switch c {
+	case escByte:
+		u.esc = true
+	case startByte:
+		u.dropped += u.wire - 1
+		u.start()
+	case endByte:
+		return true, nil
+	default:
+		u.buf = append(u.buf, c)
+	}
+	return false, nil
+}
+
+// payload checks the CRC of a completed frame and returns the data.
+func (u *unstuffer) payload() ([]byte, error) {
+	n := len(u.buf)
+	if n == 0 {
+		return nil, ErrFrameTooShort
+	}
+	data := u.buf[:n-1]
+	crc := byte(startByte) ^ checksum(&data)
+	if crc != u.buf[n-1] {
+		return nil, ErrBadCRC
+	}
+	return data, nil
+}
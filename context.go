@@ -0,0 +1,229 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readDeadliner and writeDeadliner are implemented by ports such as net.Conn
+// and *os.File that can interrupt a blocked Read or Write.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// aLongTimeAgo is used to unblock a pending Read or Write straight away.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withDeadline runs fn with the port deadline following ctx, so that fn
+// returns as soon as ctx is done.
+func withDeadline(ctx context.Context, set func(time.Time) error, fn func() error) error {
+	dl, _ := ctx.Deadline()
+	set(dl)
+	expired := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		set(aLongTimeAgo)
+		close(expired)
+	})
+	err := fn()
+	if !stop() {
+		<-expired
+	}
+	set(time.Time{})
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, os.ErrDeadlineExceeded) {
+			// the port deadline can fire just before ctx notices
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// pipeDeadline is a deadline for ports that read from a buffer rather than
+// straight from a connection, wait returns a channel closed once it passes.
+type pipeDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makePipeDeadline() pipeDeadline {
+	return pipeDeadline{cancel: make(chan struct{})}
+}
+
+// set arms the deadline, the zero time disarms it.
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer to close it
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *pipeDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+type frameResult struct {
+	f   *Frame
+	err error
+}
+
+// readPump is the one goroutine that reads from a port that cannot be
+// interrupted. A cancelled ReadFrameContext leaves the frame it was waiting
+// for to the next call instead of abandoning a goroutine per call. The pump
+// stops when the Framer is closed or the port fails.
+type readPump struct {
+	once    sync.Once
+	running int32
+	frames  chan frameResult
+	done    chan struct{}
+}
+
+func (p *readPump) started() bool {
+	return atomic.LoadInt32(&p.running) == 1
+}
+
+func (p *readPump) start(read func() (*Frame, error)) {
+	p.once.Do(func() {
+		p.frames = make(chan frameResult)
+		atomic.StoreInt32(&p.running, 1)
+		go func() {
+			defer close(p.frames)
+			for {
+				f, err := read()
+				select {
+				case p.frames <- frameResult{f, err}:
+				case <-p.done:
+					f.Release()
+					return
+				}
+				if err != nil && !isFramingError(err) {
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (p *readPump) next(ctx context.Context) (*Frame, error) {
+	select {
+	case r, ok := <-p.frames:
+		if !ok {
+			return nil, io.ErrClosedPipe
+		}
+		return r.f, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isFramingError reports whether err only spoilt one frame, so that reading
+// can carry on.
+func isFramingError(err error) bool {
+	switch err {
+	case ErrNoStartByte, ErrBadCRC, ErrBadEscape, ErrFrameTooShort, ErrFrameTooLong:
+		return true
+	}
+	return isProtocolError(err)
+}
+
+func isProtocolError(err error) bool {
+	var pe *ProtocolError
+	return errors.As(err, &pe)
+}
+
+// ReadFrameContext is ReadFrame that gives up when ctx is done. Ports with
+// SetReadDeadline are interrupted through their deadline, any other port is
+// read by a single goroutine owned by the Framer.
+func (x *x2m200Frame) ReadFrameContext(ctx context.Context) (*Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if x.pump.started() {
+		return x.pump.next(ctx)
+	}
+	if ctx.Done() == nil {
+		return x.readFrame()
+	}
+	if x.rd == nil {
+		x.pump.start(x.readFrame)
+		return x.pump.next(ctx)
+	}
+	var f *Frame
+	err := withDeadline(ctx, x.rd.SetReadDeadline, func() (err error) {
+		f, err = x.readFrame()
+		return err
+	})
+	return f, err
+}
+
+// WriteFrameContext is Write that gives up when ctx is done. Ports without
+// SetWriteDeadline are only checked before writing, as serial writes do not
+// block for long.
+func (x *x2m200Frame) WriteFrameContext(ctx context.Context, p []byte) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if x.wd == nil || ctx.Done() == nil {
+		return x.Write(p)
+	}
+	err = withDeadline(ctx, x.wd.SetWriteDeadline, func() (err error) {
+		n, err = x.Write(p)
+		return err
+	})
+	return n, err
+}
+
+// readContext copies the next frame from f into b.
+func readContext(ctx context.Context, f Framer, b []byte) (int, error) {
+	fr, err := f.ReadFrameContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(b, fr.Payload)
+	fr.Release()
+	return n, nil
+}
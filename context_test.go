@@ -0,0 +1,201 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// silentPort is a port that accepts every write and never answers, unless
+// the test writes to device.
+type silentPort struct {
+	r      *io.PipeReader
+	device *io.PipeWriter
+}
+
+func newSilentPort() *silentPort {
+	r, w := io.Pipe()
+	return &silentPort{r: r, device: w}
+}
+
+func (s *silentPort) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *silentPort) Write(p []byte) (int, error) { return ioutil.Discard.Write(p) }
+func (s *silentPort) Close() error {
+	s.device.Close()
+	return s.r.Close()
+}
+
+// waitGoroutines waits for the goroutine count to drop back to n.
+func waitGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected: %d goroutines, got %d\n", n, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	before := runtime.NumGoroutine()
+	x := Open("x2m200", newSilentPort()).(*x2m200Frame)
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		ok, err := x.Ping(10 * time.Millisecond)
+		if ok || err != ErrPingTimeout {
+			t.Errorf("Expected: %v %v, got %v %v\n", false, ErrPingTimeout, ok, err)
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Errorf("ping took %v\n", time.Since(start))
+		}
+	}
+	x.Close()
+	waitGoroutines(t, before)
+}
+
+func TestReadFrameContextKeepsFrame(t *testing.T) {
+	port := newSilentPort()
+	x := Open("x2m200", port)
+	defer x.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := x.ReadFrameContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected: %v, got %v\n", context.Canceled, err)
+	}
+
+	// a frame arriving after the cancel is handed to the next read
+	go NewFrameEncoder(port.device).Encode(respirationPayload())
+	f, err := x.ReadFrame()
+	if err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if len(f.Payload) != respsize {
+		t.Errorf("Expected: %d, got %d\n", respsize, len(f.Payload))
+	}
+	f.Release()
+}
+
+func TestReadFrameContextDeadline(t *testing.T) {
+	host, device := net.Pipe()
+	defer device.Close()
+	before := runtime.NumGoroutine()
+	x := Open("x2m200", host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := x.ReadFrameContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+	// the deadline path must not start the read pump
+	if x.(*x2m200Frame).pump.started() {
+		t.Errorf("read pump started on a port with deadlines\n")
+	}
+
+	go NewFrameEncoder(device).Encode(respirationPayload())
+	f, err := x.ReadFrameContext(context.Background())
+	if err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	f.Release()
+	x.Close()
+	waitGoroutines(t, before)
+}
+
+func TestWriteFrameContextDeadline(t *testing.T) {
+	host, device := net.Pipe()
+	defer device.Close()
+	x := Open("x2m200", host)
+	defer x.Close()
+
+	// nobody reads the device end so the write blocks until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := x.WriteFrameContext(ctx, []byte{resetCmd}); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCommandsCancel(t *testing.T) {
+	port := newSilentPort()
+	x := Open("x2m200", port)
+	defer x.Close()
+	m := NewModule(x, "respiration")
+
+	cases := []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{"Load", m.LoadContext},
+		{"SetLEDMode", m.SetLEDModeContext},
+		{"SetDetectionZone", func(ctx context.Context) error { return m.SetDetectionZoneContext(ctx, 0.5, 1.5) }},
+		{"SetSensitivity", func(ctx context.Context) error { return m.SetSensitivityContext(ctx, 5) }},
+		{"Enable", func(ctx context.Context) error { return m.EnableContext(ctx, "phase") }},
+		{"Reset", func(ctx context.Context) error { _, err := x.ResetContext(ctx); return err }},
+	}
+	for _, c := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := c.fn(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s Expected: %v, got %v\n", c.name, context.DeadlineExceeded, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	port := newSilentPort()
+	x := Open("x2m200", port)
+	defer x.Close()
+	m := NewModule(x, "respiration")
+
+	go func() {
+		e := NewFrameEncoder(port.device)
+		for i := 0; i < 3; i++ {
+			e.Encode(respirationPayload())
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- m.RunContext(ctx, stream) }()
+	for i := 0; i < 3; i++ {
+		if _, ok := (<-stream).(Respiration); !ok {
+			t.Errorf("Expected: Respiration\n")
+		}
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Expected: %v, got %v\n", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RunContext did not return after cancel")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestRunContextStartFails(t *testing.T) {
+	port := newSilentPort()
+	x := Open("x2m200", rwc{port, failingWriter{}})
+	defer port.Close()
+	m := NewModule(x, "respiration")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.RunContext(ctx, make(chan interface{})); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected: %v, got %v\n", io.ErrClosedPipe, err)
+	}
+}
@@ -0,0 +1,239 @@
+package xethru
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// replyBuffer is how many replies are kept for a command that has not
+// asked for them yet.
+const replyBuffer = 16
+
+// Demux is the one reader of a Framer. It hands app data frames to its
+// subscribers and everything else, acks, errors, system messages and
+// command responses, to the command waiting for a reply. Commands can then
+// run while data streams without either side stealing the other's frames.
+// Once a Demux reads a Framer nothing else should.
+type Demux struct {
+	f Framer
+	// cmd lets one command at a time write and wait for its reply
+	cmd     sync.Mutex
+	replies chan frameResult
+
+	startOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu   sync.Mutex
+	subs []*Subscription
+	err  error
+	// dropped counts the frames lost by all subscriptions
+	dropped atomic.Uint64
+}
+
+// NewDemux returns a Demux for f, it starts reading on first use.
+func NewDemux(f Framer) *Demux {
+	d := &Demux{
+		f:       f,
+		replies: make(chan frameResult, replyBuffer),
+		done:    make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d
+}
+
+func (d *Demux) start() {
+	d.startOnce.Do(func() { go d.run() })
+}
+
+// isDataFrame tells the frames for subscribers from replies.
+func isDataFrame(p []byte) bool {
+	return len(p) > 0 && p[0] == appDataByte
+}
+
+func (d *Demux) run() {
+	var err error
+	defer func() {
+		d.mu.Lock()
+		d.err = err
+		for _, s := range d.subs {
+			s.closeLocked()
+		}
+		d.subs = nil
+		d.mu.Unlock()
+		close(d.done)
+	}()
+	for {
+		var f *Frame
+		f, err = d.f.ReadFrameContext(d.ctx)
+		if err != nil {
+			if isProtocolError(err) {
+				d.reply(frameResult{err: err})
+				continue
+			}
+			if isFramingError(err) {
+				continue
+			}
+			if d.ctx.Err() != nil {
+				err = io.ErrClosedPipe
+			}
+			return
+		}
+		if isDataFrame(f.Payload) {
+			d.publish(f)
+			continue
+		}
+		d.reply(frameResult{f: f})
+	}
+}
+
+// reply keeps r for the next command, dropping it when nobody has asked
+// for the last replyBuffer ones.
+func (d *Demux) reply(r frameResult) {
+	select {
+	case d.replies <- r:
+	default:
+		r.f.Release()
+	}
+}
+
+// publish hands each subscriber its own copy of f, a subscriber that does
+// not keep up loses the frame rather than holding up the replies.
+func (d *Demux) publish(f *Frame) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, s := range d.subs {
+		c := f
+		if i < len(d.subs)-1 {
+			c = getFrame()
+			c.buf = append(c.buf[:0], f.Payload...)
+			c.Payload = c.buf
+		}
+		select {
+		case s.c <- c:
+		default:
+			s.dropped.Add(1)
+			d.dropped.Add(1)
+			c.Release()
+		}
+	}
+	if len(d.subs) == 0 {
+		f.Release()
+	}
+}
+
+// Subscribe returns a subscription to the data frames, buffering up to n
+// of them. The frames must be released.
+func (d *Demux) Subscribe(n int) *Subscription {
+	d.start()
+	s := &Subscription{c: make(chan *Frame, n), d: d}
+	s.C = s.c
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.done:
+		s.closeLocked()
+	default:
+		d.subs = append(d.subs, s)
+	}
+	return s
+}
+
+// drain drops the replies that came in while no command was waiting, so
+// that a command does not take the answer to an earlier one.
+func (d *Demux) drain() {
+	for {
+		select {
+		case r := <-d.replies:
+			r.f.Release()
+		default:
+			return
+		}
+	}
+}
+
+// readReply waits for the next reply.
+func (d *Demux) readReply(ctx context.Context) (*Frame, error) {
+	d.start()
+	select {
+	case r := <-d.replies:
+		return r.f, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.done:
+		// a reply may have come in just before the end
+		select {
+		case r := <-d.replies:
+			return r.f, r.err
+		default:
+		}
+		return nil, d.Err()
+	}
+}
+
+// Done is closed once the Demux stops reading, Err then tells why.
+func (d *Demux) Done() <-chan struct{} {
+	return d.done
+}
+
+// Dropped returns how many data frames were lost because a subscriber did
+// not keep up, over all the subscriptions.
+func (d *Demux) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// Err returns the error that stopped the Demux.
+func (d *Demux) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Close stops reading, the Framer is left open.
+func (d *Demux) Close() error {
+	d.cancel()
+	d.startOnce.Do(func() { close(d.done) })
+	<-d.done
+	return nil
+}
+
+// Subscription receives the data frames read by a Demux. C is closed when
+// the subscription or the Demux is closed.
+type Subscription struct {
+	C       <-chan *Frame
+	c       chan *Frame
+	d       *Demux
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Dropped returns how many frames were lost because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription and releases the frames still buffered.
+func (s *Subscription) Close() {
+	s.d.mu.Lock()
+	for i, o := range s.d.subs {
+		if o == s {
+			s.d.subs = append(s.d.subs[:i], s.d.subs[i+1:]...)
+			break
+		}
+	}
+	s.closeLocked()
+	s.d.mu.Unlock()
+	for f := range s.c {
+		f.Release()
+	}
+}
+
+func (s *Subscription) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
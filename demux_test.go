@@ -0,0 +1,252 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func respirationCounter(counter uint32) []byte {
+	b := respirationPayload()
+	binary.LittleEndian.PutUint32(b[5:9], counter)
+	return b
+}
+
+func TestDemux(t *testing.T) {
+	var in []byte
+	in = AppendFrame(in, respirationCounter(1))
+	in = AppendFrame(in, []byte{ack})
+	in = AppendFrame(in, respirationCounter(2))
+	in = AppendFrame(in, []byte{errorByte, ProtocolErrorCRCFailed})
+	in = AppendFrame(in, []byte{systemMesg, systemReady})
+	in = AppendFrame(in, respirationCounter(3))
+	port := rwc{bytes.NewReader(in), new(bytes.Buffer)}
+
+	d := NewDemux(Open("x2m200", port))
+	subs := []*Subscription{d.Subscribe(10), d.Subscribe(10)}
+
+	replies := []struct {
+		p   []byte
+		err error
+	}{
+		{[]byte{ack}, nil},
+		{nil, ErrCommandCRCFailed},
+		{[]byte{systemMesg, systemReady}, nil},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for n, c := range replies {
+		f, err := d.readReply(ctx)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		if err == nil {
+			if !bytes.Equal(f.Payload, c.p) {
+				t.Errorf("test %d Expected: %x, got %x\n", n, c.p, f.Payload)
+			}
+			f.Release()
+		}
+	}
+	for i, s := range subs {
+		var counters []uint32
+		for f := range s.C {
+			counters = append(counters, binary.LittleEndian.Uint32(f.Payload[5:9]))
+			f.Release()
+		}
+		if len(counters) != 3 || counters[0] != 1 || counters[1] != 2 || counters[2] != 3 {
+			t.Errorf("sub %d Expected: [1 2 3], got %v\n", i, counters)
+		}
+	}
+	if err := d.Err(); err != io.EOF {
+		t.Errorf("Expected: %v, got %v\n", io.EOF, err)
+	}
+}
+
+// a subscriber that does not keep up loses frames, and they are counted
+func TestDemuxDropped(t *testing.T) {
+	var in []byte
+	for i := uint32(1); i <= 5; i++ {
+		in = AppendFrame(in, respirationCounter(i))
+	}
+	r, w := io.Pipe()
+	d := NewDemux(Open("x2m200", rwc{r, new(bytes.Buffer)}))
+	slow, fast := d.Subscribe(1), d.Subscribe(5)
+	w.Write(in)
+	w.Close()
+	<-d.Done()
+	var got []int
+	for _, s := range []*Subscription{slow, fast} {
+		n := 0
+		for f := range s.C {
+			n++
+			f.Release()
+		}
+		got = append(got, n, int(s.Dropped()))
+	}
+	if expected := []int{1, 4, 5, 0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, got %v\n", expected, got)
+	}
+	if n := d.Dropped(); n != 4 {
+		t.Errorf("Expected: %d, got %d\n", 4, n)
+	}
+}
+
+// fakeDevice is the module at the other end of a net.Pipe. It answers
+// pings, acks every other command and records them, and streams frames
+// once the app is started.
+type fakeDevice struct {
+	// first is sent before the ack of the first command, data of an app
+	// that was running before
+	first []byte
+	// frame(1), frame(2)... are sent a millisecond apart once the app is
+	// started, until one is nil
+	frame func(i uint32) []byte
+	// reply, when set, answers the nth command p in place of all of the
+	// above, nil stays silent
+	reply func(n int, p []byte) []byte
+
+	mu   sync.Mutex
+	cmds [][]byte
+	// booting answers pings with not ready
+	booting bool
+}
+
+func (d *fakeDevice) serve(conn net.Conn) {
+	defer conn.Close()
+	var mu sync.Mutex
+	e := NewFrameEncoder(conn)
+	send := func(p []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := e.Encode(p)
+		return err
+	}
+	r := NewFrameDecoder(conn)
+	for n := 0; ; n++ {
+		p, err := r.Decode()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.cmds = append(d.cmds, append([]byte(nil), p...))
+		booting := d.booting
+		d.mu.Unlock()
+		if n == 0 && d.first != nil {
+			send(d.first)
+		}
+		switch {
+		case d.reply != nil:
+			if reply := d.reply(n, p); reply != nil {
+				go send(reply)
+			}
+		case p[0] == x2m200PingCommand && booting:
+			go send(pingResponse(x2m200PingResponseNotReady))
+		case p[0] == x2m200PingCommand:
+			go send(pingResponse(x2m200PingResponseReady))
+		case len(p) == 2 && p[0] == 0x20 && p[1] == 0x01:
+			go send([]byte{ack})
+			if d.frame != nil {
+				go func() {
+					for i := uint32(1); ; i++ {
+						f := d.frame(i)
+						if f == nil || send(f) != nil {
+							return
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}()
+			}
+		default:
+			go send([]byte{ack})
+		}
+	}
+}
+
+// open serves d at the other end of a net.Pipe and opens the host end.
+func (d *fakeDevice) open(device string, opts ...Option) Framer {
+	host, dev := net.Pipe()
+	go d.serve(dev)
+	return Open(device, host, opts...)
+}
+
+// module is a Module on an x2m200 served by d, closed with the test.
+func (d *fakeDevice) module(t *testing.T, mode string) *Module {
+	t.Helper()
+	x := d.open("x2m200")
+	t.Cleanup(func() { x.Close() })
+	return NewModule(x, mode)
+}
+
+// reboot makes the device answer pings as not ready from now on.
+func (d *fakeDevice) reboot() {
+	d.mu.Lock()
+	d.booting = true
+	d.mu.Unlock()
+}
+
+func (d *fakeDevice) commands() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([][]byte(nil), d.cmds...)
+}
+
+func TestModuleConfigureWhileStreaming(t *testing.T) {
+	m := (&fakeDevice{frame: respirationCounter}).module(t, "respiration")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := make(chan interface{}, 100)
+	go m.RunContext(ctx, stream)
+
+	next := func() uint32 {
+		return (<-stream).(Respiration).Counter
+	}
+	last := next()
+	for i := 0; i < 5; i++ {
+		if err := m.SetSensitivityContext(ctx, i); err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", i, nil, err)
+		}
+		if err := m.SetDetectionZoneContext(ctx, 0.5, 1.5); err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", i, nil, err)
+		}
+		// every frame sent meanwhile made it to the stream
+		for j := 0; j < 5; j++ {
+			c := next()
+			if c != last+1 {
+				t.Fatalf("test %d Expected: counter %d, got %d\n", i, last+1, c)
+			}
+			last = c
+		}
+	}
+}
+
+func TestModuleClose(t *testing.T) {
+	d := new(fakeDevice)
+	x := d.open("x2m200")
+	defer x.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// each Module leaves the Framer to the next one
+	for i := 0; i < 3; i++ {
+		m := NewModule(x, "respiration")
+		if err := m.SetSensitivityContext(ctx, i); err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", i, nil, err)
+		}
+		m.Close()
+		select {
+		case <-m.d.Done():
+		default:
+			t.Errorf("test %d Expected: the Demux stopped after Close\n", i)
+		}
+	}
+	if n := len(d.commands()); n != 3 {
+		t.Errorf("Expected: %d commands, got %d\n", 3, n)
+	}
+}
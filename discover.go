@@ -0,0 +1,145 @@
+package xethru
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceInfo describes a serial device found on the system.
+type DeviceInfo struct {
+	// Path is the device node, /dev/ttyACM0 for instance.
+	Path string
+	// The USB descriptors, zero for devices that are not on USB.
+	VendorID     uint16
+	ProductID    uint16
+	Serial       string
+	Manufacturer string
+	Product      string
+}
+
+// DiscoveredModule is a device that answered a ping.
+type DiscoveredModule struct {
+	Device   DeviceInfo
+	BaudRate int
+	// Ready is false when the module answered that it is not ready yet.
+	Ready bool
+	// Info is what a ready module says it is, zero when it did not say.
+	Info SystemInfo
+}
+
+// DiscoverConfig configures Discover, the zero value is ready to use.
+type DiscoverConfig struct {
+	// BaudRates are tried in order on each device, by default
+	// DefaultBaudRate then the other rates the modules support.
+	BaudRates []int
+	// PingTimeout bounds each ping, 200ms by default.
+	PingTimeout time.Duration
+	// AllDevices also probes serial devices that are not on USB, such as
+	// the UART of a single board computer.
+	AllDevices bool
+	// Filter, when set, picks the devices worth probing.
+	Filter func(DeviceInfo) bool
+	// Device is given to Open for the probes, "x2m200" by default.
+	Device string
+}
+
+// defaultProbeRates are the rates XeThru modules can be set to.
+var defaultProbeRates = []int{DefaultBaudRate, 921600, 460800, 230400, 57600, 9600}
+
+func (c *DiscoverConfig) defaults() {
+	if len(c.BaudRates) == 0 {
+		c.BaudRates = defaultProbeRates
+	}
+	if c.PingTimeout <= 0 {
+		c.PingTimeout = 200 * time.Millisecond
+	}
+}
+
+// openProbePort opens a device for probing, tests replace it.
+var openProbePort = func(path string, baud int) (io.ReadWriteCloser, error) {
+	return OpenSerialPort(path, SerialConfig{BaudRate: baud})
+}
+
+// Discover lists the serial devices on the system and pings each of them,
+// in parallel, at every baud rate in turn. It returns the modules that
+// answered, sorted by path. Devices that cannot be opened, busy ones for
+// instance, are skipped.
+func Discover(ctx context.Context, c DiscoverConfig) ([]DiscoveredModule, error) {
+	c.defaults()
+	devices, err := serialDevices(sysfsRoot, c.AllDevices)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		modules []DiscoveredModule
+	)
+	for _, d := range devices {
+		if c.Filter != nil && !c.Filter(d) {
+			continue
+		}
+		wg.Add(1)
+		go func(d DeviceInfo) {
+			defer wg.Done()
+			m, err := probe(ctx, d, c)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			modules = append(modules, m)
+			mu.Unlock()
+		}(d)
+	}
+	wg.Wait()
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Device.Path < modules[j].Device.Path
+	})
+	return modules, ctx.Err()
+}
+
+// Probe pings the module at path at every baud rate of c in turn and
+// returns how it answered.
+func Probe(ctx context.Context, path string, c DiscoverConfig) (DiscoveredModule, error) {
+	c.defaults()
+	return probe(ctx, DeviceInfo{Path: path}, c)
+}
+
+func probe(ctx context.Context, d DeviceInfo, c DiscoverConfig) (DiscoveredModule, error) {
+	err := ErrPingTimeout
+	for _, baud := range c.BaudRates {
+		if ctx.Err() != nil {
+			return DiscoveredModule{}, ctx.Err()
+		}
+		var m DiscoveredModule
+		m, err = probeAt(ctx, d.Path, baud, c)
+		if err == nil {
+			m.Device, m.BaudRate = d, baud
+			return m, nil
+		}
+	}
+	return DiscoveredModule{}, err
+}
+
+// probeAt pings once at one baud rate, on a fresh Framer so nothing read at
+// another rate is left over, and asks a ready module for its system info.
+func probeAt(ctx context.Context, path string, baud int, c DiscoverConfig) (DiscoveredModule, error) {
+	port, err := openProbePort(path, baud)
+	if err != nil {
+		return DiscoveredModule{}, err
+	}
+	f := Open(c.Device, port)
+	defer f.Close()
+	pctx, cancel := context.WithTimeout(ctx, c.PingTimeout)
+	ready, err := ping(pctx, f)
+	cancel()
+	if err != nil || !ready {
+		return DiscoveredModule{Ready: ready}, err
+	}
+	// older firmware may not know the command, the module is there anyway
+	info, _ := systemInfo(ctx, f, c.PingTimeout)
+	return DiscoveredModule{Ready: true, Info: info}, nil
+}
@@ -0,0 +1,73 @@
+package xethru
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysfsRoot and devRoot are where serialDevices looks, tests point them at
+// a fake tree.
+var (
+	sysfsRoot = "/sys"
+	devRoot   = "/dev"
+)
+
+// SerialDevices lists the USB serial devices on the system, or every
+// serial device with a driver behind it when all is set.
+func SerialDevices(all bool) ([]DeviceInfo, error) {
+	return serialDevices(sysfsRoot, all)
+}
+
+// serialDevices walks class/tty under root. A tty with a device link has
+// real hardware behind it, its USB descriptors are in the first parent
+// directory holding an idVendor file.
+func serialDevices(root string, all bool) ([]DeviceInfo, error) {
+	class := filepath.Join(root, "class", "tty")
+	entries, err := os.ReadDir(class)
+	if err != nil {
+		return nil, err
+	}
+	var devices []DeviceInfo
+	for _, e := range entries {
+		dev, err := filepath.EvalSymlinks(filepath.Join(class, e.Name(), "device"))
+		if err != nil {
+			// virtual terminals and the like
+			continue
+		}
+		d := DeviceInfo{Path: filepath.Join(devRoot, e.Name())}
+		usb := false
+		for dir := dev; strings.HasPrefix(dir, root) && len(dir) > len(root); dir = filepath.Dir(dir) {
+			if v, ok := sysfsHex(dir, "idVendor"); ok {
+				d.VendorID = v
+				d.ProductID, _ = sysfsHex(dir, "idProduct")
+				d.Serial = sysfsString(dir, "serial")
+				d.Manufacturer = sysfsString(dir, "manufacturer")
+				d.Product = sysfsString(dir, "product")
+				usb = true
+				break
+			}
+		}
+		if usb || all {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+func sysfsString(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func sysfsHex(dir, name string) (uint16, bool) {
+	v, err := strconv.ParseUint(sysfsString(dir, name), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(v), true
+}
@@ -0,0 +1,137 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSysfs builds a class/tty tree with a CDC ACM module, a USB serial
+// adapter, a UART and a virtual terminal.
+func fakeSysfs(t *testing.T) string {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(dir string, files map[string]string) {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, v := range files {
+			os.WriteFile(filepath.Join(root, dir, name), []byte(v+"\n"), 0644)
+		}
+	}
+	link := func(tty, dev string) {
+		write(filepath.Join("class/tty", tty), nil)
+		if dev != "" {
+			os.Symlink(filepath.Join(root, dev), filepath.Join(root, "class/tty", tty, "device"))
+		}
+	}
+	write("devices/usb1/1-1", map[string]string{"idVendor": "03eb", "idProduct": "2404", "serial": "X2M200-0001", "manufacturer": "XeThru", "product": "X2M200"})
+	write("devices/usb1/1-1/1-1:1.0", nil)
+	link("ttyACM0", "devices/usb1/1-1/1-1:1.0")
+	write("devices/usb1/1-2", map[string]string{"idVendor": "0403", "idProduct": "6001"})
+	write("devices/usb1/1-2/1-2:1.0/ttyUSB0", nil)
+	link("ttyUSB0", "devices/usb1/1-2/1-2:1.0/ttyUSB0")
+	write("devices/platform/serial0", nil)
+	link("ttyAMA0", "devices/platform/serial0")
+	link("tty1", "")
+	return root
+}
+
+func TestSerialDevices(t *testing.T) {
+	root := fakeSysfs(t)
+	usb := []DeviceInfo{
+		{Path: "/dev/ttyACM0", VendorID: 0x03eb, ProductID: 0x2404, Serial: "X2M200-0001", Manufacturer: "XeThru", Product: "X2M200"},
+		{Path: "/dev/ttyUSB0", VendorID: 0x0403, ProductID: 0x6001},
+	}
+	cases := []struct {
+		all      bool
+		expected []DeviceInfo
+	}{
+		{false, usb},
+		{true, []DeviceInfo{usb[0], {Path: "/dev/ttyAMA0"}, usb[1]}},
+	}
+	for n, c := range cases {
+		got, err := serialDevices(root, c.all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("test %d Expected: %+v, got %+v\n", n, c.expected, got)
+		}
+	}
+}
+
+// fakeModule answers pings on one end of a pipe, or stays silent when it is
+// not at the right baud rate.
+func fakeModule(conn net.Conn, ready, answer bool) {
+	defer conn.Close()
+	d := NewFrameDecoder(conn)
+	for {
+		p, err := d.Decode()
+		if err == io.EOF || errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+		if err != nil || !answer || len(p) == 0 {
+			continue
+		}
+		if p[0] == x2m200SystemInfo && ready {
+			conn.Write(AppendFrame(nil, append([]byte{x2m200Reply, x2m200SystemInfo, p[1]}, fmt.Sprintf("info %d\x00", p[1])...)))
+			continue
+		}
+		if p[0] != x2m200PingCommand {
+			continue
+		}
+		r := uint32(x2m200PingResponseNotReady)
+		if ready {
+			r = x2m200PingResponseReady
+		}
+		conn.Write(AppendFrame(nil, pingResponse(r)))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root := fakeSysfs(t)
+	defer func(r string) { sysfsRoot = r }(sysfsRoot)
+	sysfsRoot = root
+	defer func(o func(string, int) (io.ReadWriteCloser, error)) { openProbePort = o }(openProbePort)
+	// the ACM module runs at 921600 and is ready, the adapter has a
+	// booting module at the default rate
+	openProbePort = func(path string, baud int) (io.ReadWriteCloser, error) {
+		host, dev := net.Pipe()
+		switch path {
+		case "/dev/ttyACM0":
+			go fakeModule(dev, true, baud == 921600)
+		case "/dev/ttyUSB0":
+			go fakeModule(dev, false, baud == DefaultBaudRate)
+		default:
+			go fakeModule(dev, true, false)
+		}
+		return host, nil
+	}
+
+	got, err := Discover(context.Background(), DiscoverConfig{AllDevices: true, PingTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DiscoveredModule{
+		{Device: DeviceInfo{Path: "/dev/ttyACM0", VendorID: 0x03eb, ProductID: 0x2404, Serial: "X2M200-0001", Manufacturer: "XeThru", Product: "X2M200"}, BaudRate: 921600, Ready: true,
+			Info: SystemInfo{Product: "info 1", ItemNumber: "info 0", FirmwareID: "info 2", Version: "info 3", Build: "info 4", SerialNumber: "info 6"}},
+		{Device: DeviceInfo{Path: "/dev/ttyUSB0", VendorID: 0x0403, ProductID: 0x6001}, BaudRate: DefaultBaudRate, Ready: false},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %+v, got %+v\n", expected, got)
+	}
+
+	if _, err := Probe(context.Background(), "/dev/ttyAMA0", DiscoverConfig{BaudRates: []int{DefaultBaudRate}, PingTimeout: 20 * time.Millisecond}); err != ErrPingTimeout {
+		t.Errorf("Expected: %v, got %v\n", ErrPingTimeout, err)
+	}
+}
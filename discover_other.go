@@ -0,0 +1,14 @@
+//go:build !linux
+
+package xethru
+
+var sysfsRoot = ""
+
+// SerialDevices is only implemented on Linux.
+func SerialDevices(all bool) ([]DeviceInfo, error) {
+	return nil, ErrSerialNotSupported
+}
+
+func serialDevices(root string, all bool) ([]DeviceInfo, error) {
+	return nil, ErrSerialNotSupported
+}
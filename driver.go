@@ -0,0 +1,79 @@
+package xethru
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// driver is what differs between module families, the framing, the
+// transactions and the commands they share are the same for all.
+type driver struct {
+	name string
+	// apps maps the NewModule modes to the app (profile on the X4) IDs
+	apps map[string][4]byte
+	// parse turns a frame payload into a message, see parse
+	parse func(b []byte) (interface{}, error)
+	// output turns the messages with status id on (code 1) or off (0)
+	output func(id uint32, code byte) []byte
+	// baseband returns the commands Enable sends for mode, "phase", "iq"
+	// or anything else to turn baseband off
+	baseband func(mode string) [][]byte
+	// disable turns every output off, it is the first thing reset sends
+	disable [][]byte
+}
+
+// drivers are looked up by the device given to Open.
+var drivers = map[string]*driver{
+	"x2m200": x2m200Driver,
+	"x4m200": x4m200Driver,
+	"x4m300": x4m300Driver,
+}
+
+// lookupDriver returns the driver of device, case does not matter. Devices
+// that are not known get the X2M200 driver, as they always did.
+func lookupDriver(device string) *driver {
+	if d, ok := drivers[strings.ToLower(device)]; ok {
+		return d
+	}
+	return x2m200Driver
+}
+
+// driverOf returns the driver f was opened with, Framers that do not come
+// from Open, a replay for instance, are taken for an X2M200.
+func driverOf(f Framer) *driver {
+	if d, ok := f.(interface{ driver() *driver }); ok {
+		return d.driver()
+	}
+	return x2m200Driver
+}
+
+var x2m200Driver = &driver{
+	name: "x2m200",
+	apps: map[string][4]byte{
+		"respiration":      {0xd6, 0xa2, 0x23, 0x14},
+		"sleep":            {0x17, 0x7b, 0xf1, 0x00},
+		"basebandiq":       {0x14, 0x23, 0xa2, 0xd6},
+		"basebandampphase": {0x14, 0x23, 0xa2, 0xd6},
+	},
+	parse:  parse,
+	output: x2m200Output,
+	baseband: func(mode string) [][]byte {
+		switch mode {
+		case "phase":
+			return [][]byte{{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}}
+		case "iq":
+			return [][]byte{{0x90, 0x71, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}}
+		}
+		return [][]byte{{0x90, 0x71, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}
+	},
+	disable: [][]byte{{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+}
+
+// x2m200Output turns the messages starting with status id on or off.
+// Example: <Start> + <XTS_SPC_DIR_COMMAND> + <XTS_SDC_APP_SETINT> + [ID(i)] + [Length(i)] + [EnableCode(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
+func x2m200Output(id uint32, code byte) []byte {
+	p := []byte{x2m200DirCommand, x2m200AppSetInt, 0, 0, 0, 0, 0x01, 0x00, 0x00, 0x00, code, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint32(p[2:6], id)
+	return p
+}
@@ -0,0 +1,551 @@
+// Package emulator plays the part of an X2M200 module on the far end of a
+// serial line, so the driver can be run without the hardware. Serve talks
+// the protocol over any io.ReadWriter, ListenPTY puts it behind a pseudo
+// terminal that OpenSerial can open like the real tty.
+package emulator
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+// Default rates and readings, close to what a module sends.
+const (
+	DefaultRespirationInterval = time.Second / 17
+	DefaultSleepInterval       = time.Second
+	DefaultBasebandInterval    = time.Second / 17
+	DefaultBins                = 20
+	DefaultRPM                 = 14
+	DefaultDistance            = 1.0
+	DefaultSerialNumber        = "EMU00001"
+)
+
+// outBuffer is how many messages wait for the host before new ones are
+// lost.
+const outBuffer = 256
+
+// Protocol bytes, as seen from the module.
+const (
+	pingCmd        = 0x01
+	appCmd         = 0x10
+	appSet         = 0x10
+	appGet         = 0x11
+	modeCmd        = 0x20
+	modeRun        = 0x01
+	modeIdle       = 0x11
+	loadAppCmd     = 0x21
+	resetCmd       = 0x22
+	ledControlCmd  = 0x24
+	getLEDCmd      = 0x25
+	systemInfoCmd  = 0x30
+	noiseMapCmd    = 0x65
+	noiseStore     = 0x02
+	noiseLoad      = 0x03
+	noiseInit      = 0x04
+	noiseSetCtl    = 0x10
+	noiseGetCtl    = 0x11
+	noiseDelete    = 0x13
+	setBaudCmd     = 0x80
+	directCmd      = 0x90
+	directSetInt   = 0x71
+	ackByte        = 0x10
+	replyByte      = 0x14
+	errorByte      = 0x20
+	systemMesg     = 0x30
+	systemBooting  = 0x10
+	systemReady    = 0x11
+	appDataByte    = 0x50
+	errNotKnown    = 0x01
+	errCRCFailed   = 0x02
+	errInvalidApp  = 0x03
+	pingReady      = 0xaaeeaeea
+	pingNotReady   = 0xaeeaeeaa
+	basebandOff    = 0
+	basebandIQ     = 1
+	basebandAP     = 2
+	statusResp     = 594935334
+	statusSleep    = 594911596
+	statusIQ       = 0x0c
+	statusAP       = 0x0d
+	respPayload    = 29
+	sleepPayload   = 33
+	basebandHeader = 29
+)
+
+// App IDs accepted by the load command.
+var (
+	RespirationApp = [4]byte{0xd6, 0xa2, 0x23, 0x14}
+	SleepApp       = [4]byte{0x17, 0x7b, 0xf1, 0x00}
+)
+
+// Config sets how the emulated module behaves, zero values take the
+// defaults except for BootTime.
+type Config struct {
+	// BootTime is how long the module answers pings with not ready after
+	// power on or a reset, commands sent meanwhile are lost.
+	BootTime time.Duration
+	// RespirationInterval, SleepInterval and BasebandInterval are the times
+	// between two data messages.
+	RespirationInterval time.Duration
+	SleepInterval       time.Duration
+	BasebandInterval    time.Duration
+	// Bins is the number of range bins in a baseband message.
+	Bins int
+	// RPM and Distance describe the person in front of the sensor, in
+	// breaths per minute and meters.
+	RPM      float64
+	Distance float64
+	// SerialNumber is reported in the system info.
+	SerialNumber string
+}
+
+func (c *Config) defaults() {
+	if c.RespirationInterval <= 0 {
+		c.RespirationInterval = DefaultRespirationInterval
+	}
+	if c.SleepInterval <= 0 {
+		c.SleepInterval = DefaultSleepInterval
+	}
+	if c.BasebandInterval <= 0 {
+		c.BasebandInterval = DefaultBasebandInterval
+	}
+	if c.Bins <= 0 {
+		c.Bins = DefaultBins
+	}
+	if c.RPM <= 0 {
+		c.RPM = DefaultRPM
+	}
+	if c.Distance <= 0 {
+		c.Distance = DefaultDistance
+	}
+	if c.SerialNumber == "" {
+		c.SerialNumber = DefaultSerialNumber
+	}
+}
+
+// Device is an emulated X2M200.
+type Device struct {
+	c  Config
+	rw io.ReadWriter
+	e  *xethru.FrameEncoder
+	// out queues messages the way the UART does, so commands are still
+	// read while the host is not reading
+	out chan []byte
+
+	mu      sync.Mutex
+	booted  bool
+	boots   int
+	app     [4]byte
+	running bool
+	// muted has the app messages turned off, by status
+	muted    map[uint32]bool
+	baseband byte
+	led      byte
+	// settings holds the value of each app setting by ID
+	settings map[[4]byte][]byte
+	// noiseStored lives in flash and survives a reset, the control does
+	// not
+	noiseStored  bool
+	noiseControl uint32
+	// each output counts its own messages
+	appCount      uint32
+	basebandCount uint32
+	epoch         time.Time
+}
+
+// New returns a module talking on rw, it does nothing until Serve.
+func New(rw io.ReadWriter, c Config) *Device {
+	c.defaults()
+	return &Device{c: c, rw: rw, e: xethru.NewFrameEncoder(rw), out: make(chan []byte, outBuffer), epoch: time.Now()}
+}
+
+// Serve boots the module, then answers commands and streams data until ctx
+// is done or rw fails. A Read blocked on rw is only given up once rw is
+// closed.
+func (d *Device) Serve(ctx context.Context) error {
+	errc := make(chan error, 2)
+	go func() { errc <- d.readCommands() }()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { errc <- d.writeMessages(stop) }()
+	d.Reboot()
+
+	resp := time.NewTicker(d.c.RespirationInterval)
+	defer resp.Stop()
+	sleep := time.NewTicker(d.c.SleepInterval)
+	defer sleep.Stop()
+	baseband := time.NewTicker(d.c.BasebandInterval)
+	defer baseband.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return err
+		case <-resp.C:
+			d.streamApp(RespirationApp)
+		case <-sleep.C:
+			d.streamApp(SleepApp)
+		case <-baseband.C:
+			d.streamBaseband()
+		}
+	}
+}
+
+func (d *Device) writeMessages(stop chan struct{}) error {
+	for {
+		select {
+		case p := <-d.out:
+			if _, err := d.e.Encode(p); err != nil {
+				return err
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Reboot restarts the module as a power cycle would: output stops, the app
+// is unloaded and the boot messages are sent again.
+func (d *Device) Reboot() {
+	d.mu.Lock()
+	d.booted = false
+	d.boots++
+	boot := d.boots
+	d.app = [4]byte{}
+	d.running = false
+	d.baseband = basebandOff
+	d.muted = map[uint32]bool{}
+	d.led, d.settings = defaultSettings()
+	d.noiseControl = defaultNoiseControl
+	d.mu.Unlock()
+
+	d.send(systemMesg, systemBooting)
+	time.AfterFunc(d.c.BootTime, func() {
+		d.mu.Lock()
+		if d.boots != boot {
+			// reset again while booting
+			d.mu.Unlock()
+			return
+		}
+		d.booted = true
+		d.mu.Unlock()
+		d.send(systemMesg, systemReady)
+	})
+}
+
+// send queues p, dropping it when the host has fallen too far behind.
+func (d *Device) send(p ...byte) {
+	select {
+	case d.out <- p:
+	default:
+	}
+}
+
+// readCommands answers commands until rw fails, frames that do not decode
+// are dropped the way the module does.
+func (d *Device) readCommands() error {
+	dec := xethru.NewFrameDecoder(d.rw)
+	for {
+		p, err := dec.Decode()
+		switch {
+		case err == nil:
+			d.handle(p)
+		case errors.Is(err, xethru.ErrBadCRC):
+			d.send(errorByte, errCRCFailed)
+		case errors.Is(err, xethru.ErrNoStartByte), errors.Is(err, xethru.ErrBadEscape),
+			errors.Is(err, xethru.ErrFrameTooShort), errors.Is(err, xethru.ErrFrameTooLong):
+		default:
+			return err
+		}
+	}
+}
+
+func (d *Device) handle(p []byte) {
+	d.mu.Lock()
+	booted := d.booted
+	d.mu.Unlock()
+	if len(p) == 0 {
+		return
+	}
+	if p[0] == pingCmd {
+		r := make([]byte, 5)
+		r[0] = pingCmd
+		binary.BigEndian.PutUint32(r[1:], pingNotReady)
+		if booted {
+			binary.BigEndian.PutUint32(r[1:], pingReady)
+		}
+		d.send(r...)
+		return
+	}
+	if !booted {
+		return
+	}
+
+	switch {
+	case p[0] == resetCmd:
+		d.send(ackByte)
+		d.Reboot()
+		return
+	case p[0] == loadAppCmd && len(p) == 5:
+		var app [4]byte
+		copy(app[:], p[1:])
+		if app != RespirationApp && app != SleepApp {
+			d.send(errorByte, errInvalidApp)
+			return
+		}
+		d.mu.Lock()
+		d.app = app
+		d.running = false
+		d.mu.Unlock()
+	case p[0] == modeCmd && len(p) == 2 && p[1] == modeRun:
+		d.mu.Lock()
+		loaded := d.app != [4]byte{}
+		d.running = loaded
+		d.mu.Unlock()
+		if !loaded {
+			d.send(errorByte, errInvalidApp)
+			return
+		}
+	case p[0] == modeCmd && len(p) == 2 && p[1] == modeIdle:
+		d.mu.Lock()
+		d.running = false
+		d.mu.Unlock()
+	case p[0] == ledControlCmd && len(p) == 3:
+		d.mu.Lock()
+		d.led = p[1]
+		d.mu.Unlock()
+	case p[0] == getLEDCmd && len(p) == 1:
+		d.mu.Lock()
+		led := d.led
+		d.mu.Unlock()
+		d.send(replyByte, getLEDCmd, led)
+		return
+	// a pseudo terminal has no baud rate, the ack is all there is to do
+	case p[0] == setBaudCmd && len(p) == 5:
+	case p[0] == systemInfoCmd && len(p) == 2:
+		info, ok := d.systemInfo(p[1])
+		if !ok {
+			d.send(errorByte, errNotKnown)
+			return
+		}
+		d.send(append(append([]byte{replyByte, systemInfoCmd, p[1]}, info...), 0)...)
+		return
+	case p[0] == noiseMapCmd && len(p) > 1:
+		if !d.noiseMap(p) {
+			return
+		}
+	case p[0] == appCmd && len(p) > 6 && p[1] == appSet:
+		var id [4]byte
+		copy(id[:], p[2:6])
+		d.mu.Lock()
+		d.settings[id] = append([]byte(nil), p[6:]...)
+		d.mu.Unlock()
+	case p[0] == appCmd && len(p) == 6 && p[1] == appGet:
+		var id [4]byte
+		copy(id[:], p[2:6])
+		d.mu.Lock()
+		v, ok := d.settings[id]
+		d.mu.Unlock()
+		if !ok {
+			d.send(errorByte, errNotKnown)
+			return
+		}
+		d.send(append([]byte{replyByte, appGet, id[0], id[1], id[2], id[3]}, v...)...)
+		return
+	// <XTS_SPC_DIR_COMMAND> + <XTS_SDC_APP_SETINT> + [ID(i)] + [Length(i)] + [Code(i)]
+	// the app messages are keyed by their status, anything else is baseband
+	case p[0] == directCmd && len(p) == 14 && p[1] == directSetInt:
+		id, code := binary.LittleEndian.Uint32(p[2:6]), p[10]
+		switch {
+		case (id == statusResp || id == statusSleep) && code <= 1:
+			d.mu.Lock()
+			d.muted[id] = code == 0
+			d.mu.Unlock()
+		case id != statusResp && id != statusSleep && code <= basebandAP:
+			d.mu.Lock()
+			d.baseband = code
+			d.mu.Unlock()
+		default:
+			d.send(errorByte, errNotKnown)
+			return
+		}
+	default:
+		d.send(errorByte, errNotKnown)
+		return
+	}
+	d.send(ackByte)
+}
+
+// defaultNoiseControl enables the noise map and keeps adapting it.
+const defaultNoiseControl = 0x03
+
+// noiseMap handles the noise map commands, it returns true when the
+// command is to be acked.
+func (d *Device) noiseMap(p []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case p[1] == noiseStore && len(p) == 2:
+		d.noiseStored = true
+	case p[1] == noiseLoad && len(p) == 2:
+		if !d.noiseStored {
+			d.send(errorByte, errNotKnown)
+			return false
+		}
+	case p[1] == noiseDelete && len(p) == 2:
+		d.noiseStored = false
+	case p[1] == noiseInit && len(p) == 2:
+	case p[1] == noiseSetCtl && len(p) == 6:
+		d.noiseControl = binary.LittleEndian.Uint32(p[2:])
+	case p[1] == noiseGetCtl && len(p) == 2:
+		r := []byte{replyByte, noiseMapCmd, noiseGetCtl, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(r[3:], d.noiseControl)
+		d.send(r...)
+		return false
+	default:
+		d.send(errorByte, errNotKnown)
+		return false
+	}
+	return true
+}
+
+// systemInfo returns the item code of the system info.
+func (d *Device) systemInfo(code byte) (string, bool) {
+	switch code {
+	case 0x00:
+		return "XTMOD-X2M200-EMU", true
+	case 0x01:
+		return "X2M200", true
+	case 0x02:
+		return "XTX2M200", true
+	case 0x03:
+		return "1.3.4", true
+	case 0x04:
+		return "emulator", true
+	case 0x06:
+		return d.c.SerialNumber, true
+	}
+	return "", false
+}
+
+// defaultSettings returns the LED mode and app settings of a module after
+// power on: full LED, detection zone 0.5m to 1.2m and sensitivity 5.
+func defaultSettings() (byte, map[[4]byte][]byte) {
+	zone := make([]byte, 8)
+	binary.LittleEndian.PutUint32(zone[0:], math.Float32bits(0.5))
+	binary.LittleEndian.PutUint32(zone[4:], math.Float32bits(1.2))
+	return 2, map[[4]byte][]byte{
+		{0x1c, 0x0a, 0xa1, 0x96}: zone,
+		{0x2b, 0x11, 0xa5, 0x10}: {5, 0, 0, 0},
+	}
+}
+
+// breath returns how far the chest has moved in mm at now.
+func (d *Device) breath(now time.Time) float64 {
+	t := now.Sub(d.epoch).Seconds()
+	return 5 * math.Sin(2*math.Pi*t*d.c.RPM/60)
+}
+
+func (d *Device) streamApp(app [4]byte) {
+	d.mu.Lock()
+	if !d.running || d.app != app || (app == RespirationApp && d.muted[statusResp]) || (app == SleepApp && d.muted[statusSleep]) {
+		d.mu.Unlock()
+		return
+	}
+	d.appCount++
+	counter := d.appCount
+	d.mu.Unlock()
+
+	now := time.Now()
+	movement := d.breath(now)
+	distance := d.c.Distance + movement/1000
+	var b []byte
+	switch app {
+	case RespirationApp:
+		b = make([]byte, respPayload)
+		binary.LittleEndian.PutUint32(b[1:5], statusResp)
+		binary.LittleEndian.PutUint32(b[13:17], uint32(math.Round(d.c.RPM)))
+		putFloat(b[17:21], distance)
+		putFloat(b[21:25], movement)
+		binary.LittleEndian.PutUint32(b[25:29], 10)
+	case SleepApp:
+		b = make([]byte, sleepPayload)
+		binary.LittleEndian.PutUint32(b[1:5], statusSleep)
+		putFloat(b[13:17], d.c.RPM)
+		putFloat(b[17:21], distance)
+		binary.LittleEndian.PutUint32(b[21:25], 10)
+		putFloat(b[25:29], math.Abs(movement)/5)
+		putFloat(b[29:33], rand.Float64())
+	}
+	b[0] = appDataByte
+	binary.LittleEndian.PutUint32(b[5:9], counter)
+	// state breathing
+	binary.LittleEndian.PutUint32(b[9:13], 0)
+	d.send(b...)
+}
+
+// streamBaseband sends the radar signal: noise, with the person showing up
+// in the bin at Distance and their breathing in its phase.
+func (d *Device) streamBaseband() {
+	d.mu.Lock()
+	mode := d.baseband
+	if mode == basebandOff {
+		d.mu.Unlock()
+		return
+	}
+	d.basebandCount++
+	counter := d.basebandCount
+	d.mu.Unlock()
+
+	const (
+		binLength    = 0.0514
+		samplingFreq = 39e9
+		carrierFreq  = 7.29e9
+		rangeOffset  = 0.3
+	)
+	bins := d.c.Bins
+	b := make([]byte, basebandHeader+8*bins)
+	b[0] = appDataByte
+	status := uint32(statusAP)
+	if mode == basebandIQ {
+		status = statusIQ
+	}
+	binary.LittleEndian.PutUint32(b[1:5], status)
+	binary.LittleEndian.PutUint32(b[5:9], counter)
+	binary.LittleEndian.PutUint32(b[9:13], uint32(bins))
+	putFloat(b[13:17], binLength)
+	putFloat(b[17:21], samplingFreq)
+	putFloat(b[21:25], carrierFreq)
+	putFloat(b[25:29], rangeOffset)
+
+	target := int((d.c.Distance - rangeOffset) / binLength)
+	phase := 4 * math.Pi * d.breath(time.Now()) / 1000 * carrierFreq / 299792458
+	first, second := b[basebandHeader:], b[basebandHeader+4*bins:]
+	for i := 0; i < bins; i++ {
+		amp, ph := 0.001*rand.Float64(), (rand.Float64()*2-1)*math.Pi
+		if i == target {
+			amp, ph = 0.05, phase
+		}
+		if mode == basebandIQ {
+			putFloat(first[4*i:], amp*math.Cos(ph))
+			putFloat(second[4*i:], amp*math.Sin(ph))
+			continue
+		}
+		putFloat(first[4*i:], amp)
+		putFloat(second[4*i:], ph)
+	}
+	d.send(b...)
+}
+
+func putFloat(b []byte, v float64) {
+	binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+}
@@ -0,0 +1,193 @@
+package emulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+// serve runs a module on one end of a pipe and returns a Framer on the other.
+func serve(t *testing.T, c Config) (xethru.Framer, *Device) {
+	host, dev := net.Pipe()
+	d := New(dev, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Serve(ctx)
+		close(done)
+	}()
+	x := xethru.Open("x2m200", host)
+	t.Cleanup(func() {
+		cancel()
+		x.Close()
+		dev.Close()
+		<-done
+	})
+	return x, d
+}
+
+func TestBoot(t *testing.T) {
+	x, d := serve(t, Config{BootTime: 50 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cases := []struct {
+		wait  time.Duration
+		ready bool
+	}{
+		{0, false},
+		{100 * time.Millisecond, true},
+	}
+	for n, c := range cases {
+		time.Sleep(c.wait)
+		ready, err := x.(interface {
+			PingContext(context.Context) (bool, error)
+		}).PingContext(ctx)
+		if ready != c.ready || err != nil {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, c.ready, nil, ready, err)
+		}
+	}
+
+	m := xethru.NewModule(x, "respiration")
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	// a reboot loses the app
+	d.Reboot()
+	time.Sleep(100 * time.Millisecond)
+	stream := make(chan interface{})
+	rctx, rcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer rcancel()
+	go m.RunContext(rctx, stream)
+	select {
+	case v := <-stream:
+		t.Errorf("Expected: no data, got %+v\n", v)
+	case <-rctx.Done():
+	}
+}
+
+func TestCommands(t *testing.T) {
+	x, _ := serve(t, Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	time.Sleep(10 * time.Millisecond)
+	if ok, err := x.ResetContext(ctx); !ok || err != nil {
+		t.Fatalf("Expected: %v %v, got %v %v\n", true, nil, ok, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	unknown := xethru.Command[struct{}]{Name: "unknown", Payload: []byte{0x42}, Reply: func(p []byte) (struct{}, bool, error) {
+		return struct{}{}, false, nil
+	}}
+	if _, err := xethru.Transact(ctx, x, unknown); !errors.Is(err, xethru.ErrCommandNotRecognised) {
+		t.Errorf("Expected: %v, got %v\n", xethru.ErrCommandNotRecognised, err)
+	}
+
+	// from here on the Module reads the Framer
+	m := xethru.NewModule(x, "respiration")
+	cases := []struct {
+		name string
+		fn   func() error
+		err  error
+	}{
+		{"Load", func() error { return m.LoadContext(ctx) }, nil},
+		{"SetLEDMode", func() error { return m.SetLEDModeContext(ctx) }, nil},
+		{"SetDetectionZone", func() error { return m.SetDetectionZoneContext(ctx, 0.5, 1.5) }, nil},
+		{"SetSensitivity", func() error { return m.SetSensitivityContext(ctx, 5) }, nil},
+		{"Verify", func() error { return m.VerifyContext(ctx) }, nil},
+		{"Verify changed", func() error {
+			m.Sensitivity = 7
+			return m.VerifyContext(ctx)
+		}, xethru.ErrConfigMismatch},
+		{"SystemInfo", func() error {
+			s, err := m.SystemInfoContext(ctx)
+			if err == nil && (s.Product != "X2M200" || s.SerialNumber != DefaultSerialNumber) {
+				return fmt.Errorf("system info %v", s)
+			}
+			return err
+		}, nil},
+		{"LoadNoiseMap", func() error { return m.LoadNoiseMapContext(ctx) }, xethru.ErrCommandNotRecognised},
+		{"StoreNoiseMap", func() error { return m.StoreNoiseMapContext(ctx) }, nil},
+		{"LoadNoiseMap stored", func() error { return m.LoadNoiseMapContext(ctx) }, nil},
+		{"NoiseMapControl", func() error {
+			if err := m.SetNoiseMapControlContext(ctx, xethru.NoiseMapEnable|xethru.NoiseMapInitOnStart); err != nil {
+				return err
+			}
+			c, err := m.GetNoiseMapControlContext(ctx)
+			if err == nil && c != xethru.NoiseMapEnable|xethru.NoiseMapInitOnStart {
+				return fmt.Errorf("noise map control %v", c)
+			}
+			return err
+		}, nil},
+		{"RelearnNoiseMap", func() error { return m.RelearnNoiseMapContext(ctx) }, nil},
+		{"DeleteNoiseMap", func() error { return m.DeleteNoiseMapContext(ctx) }, nil},
+		{"SetSleepOutput", func() error { return m.SetSleepOutputContext(ctx, false) }, nil},
+		{"Enable", func() error { return m.EnableContext(ctx, "phase") }, nil},
+	}
+	for _, c := range cases {
+		if err := c.fn(); !errors.Is(err, c.err) {
+			t.Errorf("%s Expected: %v, got %v\n", c.name, c.err, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	interval := 5 * time.Millisecond
+	cases := []struct {
+		mode   string
+		load   bool
+		enable string
+		check  func(v interface{}) bool
+	}{
+		{"respiration", true, "", func(v interface{}) bool {
+			r, ok := v.(xethru.Respiration)
+			return ok && r.RPM == DefaultRPM && r.Distance > 0.9 && r.Distance < 1.1
+		}},
+		{"sleep", true, "", func(v interface{}) bool {
+			s, ok := v.(xethru.Sleep)
+			return ok && s.RPM == DefaultRPM
+		}},
+		// baseband output does not need an app
+		{"basebandampphase", false, "phase", func(v interface{}) bool {
+			b, ok := v.(xethru.BaseBandAmpPhase)
+			return ok && b.Bins == DefaultBins && len(b.Phase) == DefaultBins
+		}},
+		{"basebandiq", false, "iq", func(v interface{}) bool {
+			b, ok := v.(xethru.BaseBandIQ)
+			return ok && b.Bins == DefaultBins && len(b.SigQ) == DefaultBins
+		}},
+	}
+	for n, c := range cases {
+		x, _ := serve(t, Config{RespirationInterval: interval, SleepInterval: interval, BasebandInterval: interval})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		time.Sleep(10 * time.Millisecond)
+		m := xethru.NewModule(x, c.mode)
+		if c.load {
+			if err := m.LoadContext(ctx); err != nil {
+				t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+			}
+		}
+		if c.enable != "" {
+			if err := m.EnableContext(ctx, c.enable); err != nil {
+				t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+			}
+		}
+		stream := make(chan interface{})
+		go m.RunContext(ctx, stream)
+		for i := 0; i < 3; i++ {
+			select {
+			case v := <-stream:
+				if !c.check(v) {
+					t.Errorf("test %d Expected: %s data, got %+v\n", n, c.mode, v)
+				}
+			case <-ctx.Done():
+				t.Fatalf("test %d Expected: data, got %v\n", n, ctx.Err())
+			}
+		}
+		cancel()
+	}
+}
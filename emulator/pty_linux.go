@@ -0,0 +1,96 @@
+//go:build !ppc64 && !ppc64le
+
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+// PTY is an emulated module behind a pseudo terminal, the driver opens
+// Path like the tty of a real module.
+type PTY struct {
+	// Device is the module, Reboot it to emulate a power cycle.
+	Device *Device
+	path   string
+	master *os.File
+	// slave is held open so the terminal stays raw and the master does not
+	// see a hang up between two users of the tty
+	slave  *xethru.SerialPort
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// ListenPTY creates a pseudo terminal and serves an emulated module on it
+// until Close.
+func ListenPTY(c Config) (*PTY, error) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	path, err := unlockPTY(m)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	s, err := xethru.OpenSerialPort(path, xethru.SerialConfig{Shared: true})
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	p := &PTY{Device: New(m, c), path: path, master: m, slave: s, done: make(chan struct{})}
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	go func() {
+		p.err = p.Device.Serve(ctx)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+func unlockPTY(m *os.File) (string, error) {
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		return "", fmt.Errorf("unlock pty: %w", errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		return "", fmt.Errorf("pty number: %w", errno)
+	}
+	return fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// Path is the tty to open, /dev/pts/3 for instance.
+func (p *PTY) Path() string {
+	return p.path
+}
+
+// Done is closed once the module stops, Err then tells why.
+func (p *PTY) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the error that stopped the module.
+func (p *PTY) Err() error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the module and removes the terminal.
+func (p *PTY) Close() error {
+	p.cancel()
+	err := p.master.Close()
+	p.slave.Close()
+	<-p.done
+	return err
+}
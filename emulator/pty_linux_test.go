@@ -0,0 +1,39 @@
+//go:build !ppc64 && !ppc64le
+
+package emulator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+func TestListenPTY(t *testing.T) {
+	p, err := ListenPTY(Config{RespirationInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	defer p.Close()
+
+	x, err := xethru.OpenSerial(p.Path(), xethru.SerialConfig{})
+	if err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	defer x.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if ok, err := x.ResetContext(ctx); !ok || err != nil {
+		t.Fatalf("Expected: %v %v, got %v %v\n", true, nil, ok, err)
+	}
+	m := xethru.NewModule(x, "respiration")
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	stream := make(chan interface{})
+	go m.RunContext(ctx, stream)
+	if _, ok := (<-stream).(xethru.Respiration); !ok {
+		t.Errorf("Expected: respiration data\n")
+	}
+}
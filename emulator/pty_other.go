@@ -0,0 +1,38 @@
+//go:build !linux || ppc64 || ppc64le
+
+package emulator
+
+import (
+	"github.com/NeuralSpaz/xethru"
+)
+
+// PTY is only implemented on Linux.
+type PTY struct {
+	// Device is the module, Reboot it to emulate a power cycle.
+	Device *Device
+}
+
+// ListenPTY is only implemented on Linux.
+func ListenPTY(c Config) (*PTY, error) {
+	return nil, xethru.ErrSerialNotSupported
+}
+
+// Path is only implemented on Linux.
+func (p *PTY) Path() string {
+	return ""
+}
+
+// Done is only implemented on Linux.
+func (p *PTY) Done() <-chan struct{} {
+	return nil
+}
+
+// Err is only implemented on Linux.
+func (p *PTY) Err() error {
+	return xethru.ErrSerialNotSupported
+}
+
+// Close is only implemented on Linux.
+func (p *PTY) Close() error {
+	return nil
+}
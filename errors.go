@@ -0,0 +1,118 @@
+package xethru
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Framing errors, each one spoils a single frame and reading can carry on.
+var (
+	ErrNoStartByte   = errors.New("no startbyte")
+	ErrBadCRC        = errors.New("failed checksum")
+	ErrBadEscape     = errors.New("escape byte not followed by a flag byte")
+	ErrFrameTooShort = errors.New("frame not long enough")
+	ErrFrameTooLong  = errors.New("frame longer than the maximum frame size")
+)
+
+// Parse errors, returned wrapped in a *ParseError.
+var (
+	ErrNoData              = errors.New("no data to parse")
+	ErrParseNotImplemented = errors.New("parser not implemented")
+	ErrPayloadTooShort     = errors.New("payload does not contain enough bytes")
+	ErrPayloadIncomplete   = errors.New("payload does not contain a full packet of data")
+	ErrPayloadTooLong      = errors.New("payload contains more bytes than expected")
+	ErrTooManyBins         = errors.New("baseband bin count out of range")
+)
+
+// Command errors.
+var (
+	ErrNoAck           = errors.New("command was not acknowledged")
+	ErrCommandTimeout  = errors.New("command timed out")
+	ErrPingTimeout     = errors.New("ping timeout")
+	ErrBadPingResponse = errors.New("ping response does not contain a valid ping response")
+)
+
+// ErrBaudRateNotSupported is returned when the port under a Module has no
+// baud rate to change, a raw TCP bridge for instance.
+var ErrBaudRateNotSupported = errors.New("baud rate of the port cannot be changed")
+
+// ErrConfigMismatch is returned by Verify for each setting the module
+// reads back differently from the one set.
+var ErrConfigMismatch = errors.New("module setting differs from the one set")
+
+// ErrUnknownMode is returned by Load when NewModule was given a mode the
+// module does not have an app for.
+var ErrUnknownMode = errors.New("no app for the mode on this module")
+
+// ErrSilent is why a Supervisor gives up on a link that stopped sending
+// data.
+var ErrSilent = errors.New("no data from the module")
+
+// ErrNotReady is why a Supervisor gives up on a module that answers a ping
+// as not ready, it has rebooted and lost its configuration.
+var ErrNotReady = errors.New("module is not ready")
+
+// Protocol error codes sent by the module in an error frame.
+const (
+	ProtocolErrorNotRecognised byte = 0x01
+	ProtocolErrorCRCFailed     byte = 0x02
+	ProtocolErrorInvalidAppID  byte = 0x03
+)
+
+// Protocol errors sent by the module, use errors.Is to match them against
+// an error returned by a command.
+var (
+	ErrCommandNotRecognised error = &ProtocolError{Code: ProtocolErrorNotRecognised}
+	ErrCommandCRCFailed     error = &ProtocolError{Code: ProtocolErrorCRCFailed}
+	ErrInvalidAppID         error = &ProtocolError{Code: ProtocolErrorInvalidAppID}
+)
+
+// ProtocolError is an error frame sent by the module:
+// <Start> + <XTS_SPR_ERROR> + <ErrorCode> + <CRC> + <End>
+type ProtocolError struct {
+	Code byte
+}
+
+func (e *ProtocolError) Error() string {
+	switch e.Code {
+	case ProtocolErrorNotRecognised:
+		return "protocol error command not recognised"
+	case ProtocolErrorCRCFailed:
+		return "protocol error command bad crc"
+	case ProtocolErrorInvalidAppID:
+		return "protocol error invalid app id"
+	}
+	return fmt.Sprintf("protocol error %#02x", e.Code)
+}
+
+// Is makes any ProtocolError match the sentinel with the same code.
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code
+}
+
+// ParseError is returned when a frame could not be turned into a message.
+// Payload is a copy of the frame so it can be inspected later.
+type ParseError struct {
+	Type    byte
+	Payload []byte
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse message %#02x: %v", e.Type, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError copies b so the error outlives the frame it came from.
+func newParseError(b []byte, err error) *ParseError {
+	e := &ParseError{Err: err}
+	if len(b) > 0 {
+		e.Type = b[0]
+		e.Payload = append([]byte(nil), b...)
+	}
+	return e
+}
@@ -0,0 +1,79 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProtocolError(t *testing.T) {
+	cases := []struct {
+		code     byte
+		sentinel error
+	}{
+		{ProtocolErrorNotRecognised, ErrCommandNotRecognised},
+		{ProtocolErrorCRCFailed, ErrCommandCRCFailed},
+		{ProtocolErrorInvalidAppID, ErrInvalidAppID},
+		{0x42, nil},
+	}
+	for n, c := range cases {
+		x := NewXethruReader(bytes.NewReader(AppendFrame(nil, []byte{errorByte, c.code})))
+		_, err := x.Read(make([]byte, 16))
+		var pe *ProtocolError
+		if !errors.As(err, &pe) {
+			t.Fatalf("test %d Expected: *ProtocolError, got %#v\n", n, err)
+		}
+		if pe.Code != c.code {
+			t.Errorf("test %d Expected: %#02x, got %#02x\n", n, c.code, pe.Code)
+		}
+		if c.sentinel != nil && !errors.Is(err, c.sentinel) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.sentinel, err)
+		}
+		if errors.Is(err, ErrBadCRC) {
+			t.Errorf("test %d matched an unrelated sentinel\n", n)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		b   []byte
+		typ byte
+		err error
+	}{
+		{[]byte{appDataByte, respirationStartByte, 0x01}, appDataByte, ErrPayloadTooShort},
+		{[]byte{0x99, 0x01}, 0x99, ErrParseNotImplemented},
+	}
+	for n, c := range cases {
+		_, err := parse(c.b)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("test %d Expected: *ParseError, got %#v\n", n, err)
+		}
+		if pe.Type != c.typ || !bytes.Equal(pe.Payload, c.b) || !errors.Is(err, c.err) {
+			t.Errorf("test %d Expected: %#02x %x %v, got %#02x %x %v\n", n, c.typ, c.b, c.err, pe.Type, pe.Payload, pe.Err)
+		}
+		// the payload must not alias the frame buffer
+		c.b[0] = 0xff
+		if pe.Payload[0] == 0xff {
+			t.Errorf("test %d payload aliases the input\n", n)
+		}
+	}
+}
+
+func TestCommandWrapsProtocolError(t *testing.T) {
+	port := newSilentPort()
+	x := Open("x2m200", port)
+	defer x.Close()
+	m := NewModule(x, "respiration")
+
+	go NewFrameEncoder(port.device).Encode([]byte{errorByte, ProtocolErrorInvalidAppID})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := m.LoadContext(ctx)
+	if !errors.Is(err, ErrInvalidAppID) {
+		t.Errorf("Expected: %v, got %v\n", ErrInvalidAppID, err)
+	}
+}
@@ -0,0 +1,44 @@
+package xethru
+
+import "sync"
+
+// frameBufferSize is the initial capacity of pooled frames, big enough for
+// every app message and most baseband frames.
+const frameBufferSize = 2048
+
+// Frame is a decoded frame borrowed from a pool by ReadFrame. Payload is
+// only valid until Release is called, after which the frame must not be used.
+type Frame struct {
+	Payload []byte
+	buf     []byte
+}
+
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return &Frame{buf: make([]byte, 0, frameBufferSize)}
+	},
+}
+
+func getFrame() *Frame {
+	return framePool.Get().(*Frame)
+}
+
+// Release returns the frame to the pool.
+func (f *Frame) Release() {
+	if f == nil {
+		return
+	}
+	f.Payload = nil
+	framePool.Put(f)
+}
+
+// decode reads the next frame from d into the frame's buffer.
+func (f *Frame) decode(d *FrameDecoder) error {
+	p, err := d.DecodeTo(f.buf)
+	if err != nil {
+		return err
+	}
+	f.buf = p[:0]
+	f.Payload = p
+	return nil
+}
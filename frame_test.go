@@ -0,0 +1,121 @@
+package xethru
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// loopReader replays the same bytes forever.
+type loopReader struct {
+	b   []byte
+	off int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, l.b[l.off:])
+	l.off = (l.off + n) % len(l.b)
+	return n, nil
+}
+
+func respirationPayload() []byte {
+	b := make([]byte, respsize)
+	b[0] = appDataByte
+	binary.LittleEndian.PutUint32(b[1:5], uint32(respApp))
+	return b
+}
+
+func basebandIQPayload(bins uint32) []byte {
+	b := make([]byte, iqheadersize+int(bins)*8)
+	b[0] = appDataByte
+	binary.LittleEndian.PutUint32(b[1:5], uint32(basebandIQ))
+	binary.LittleEndian.PutUint32(b[9:13], bins)
+	// make sure some of the samples need escaping
+	for i := iqheadersize; i < len(b); i++ {
+		b[i] = byte(0x7c + i%4)
+	}
+	return b
+}
+
+func newLoopFramer(p []byte) *x2m200Frame {
+	return newX2M200Frame(nil, &loopReader{b: AppendFrame(nil, p)}, nil)
+}
+
+func TestReadFrame(t *testing.T) {
+	cases := [][]byte{
+		respirationPayload(),
+		basebandIQPayload(138),
+		basebandIQPayload(1024),
+	}
+	for n, c := range cases {
+		x := newLoopFramer(c)
+		for i := 0; i < 3; i++ {
+			f, err := x.ReadFrame()
+			if err != nil {
+				t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+			}
+			if !bytes.Equal(f.Payload, c) {
+				t.Errorf("test %d Expected: %x, got %x\n", n, c, f.Payload)
+			}
+			f.Release()
+		}
+	}
+}
+
+func TestReadFrameDoesNotAllocate(t *testing.T) {
+	cases := [][]byte{
+		respirationPayload(),
+		basebandIQPayload(138),
+	}
+	for n, c := range cases {
+		x := newLoopFramer(c)
+		allocs := testing.AllocsPerRun(1000, func() {
+			f, err := x.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Release()
+		})
+		if allocs != 0 {
+			t.Errorf("test %d Expected: %d allocs, got %v\n", n, 0, allocs)
+		}
+	}
+}
+
+func benchmarkReadFrame(b *testing.B, p []byte) {
+	x := newLoopFramer(p)
+	b.SetBytes(int64(len(p)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := x.ReadFrame()
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Release()
+	}
+}
+
+func BenchmarkReadFrameRespiration(b *testing.B) {
+	benchmarkReadFrame(b, respirationPayload())
+}
+
+func BenchmarkReadFrameBaseBandIQ(b *testing.B) {
+	benchmarkReadFrame(b, basebandIQPayload(138))
+}
+
+func BenchmarkReadFrameBaseBandIQLarge(b *testing.B) {
+	benchmarkReadFrame(b, basebandIQPayload(1024))
+}
+
+// BenchmarkReadRespiration measures the copying io.Reader path for comparison.
+func BenchmarkReadRespiration(b *testing.B) {
+	x := newLoopFramer(respirationPayload())
+	buf := make([]byte, frameBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := x.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of the link of a NetPort.
+type ConnState int
+
+// Link states reported by a NetPort.
+const (
+	StateConnecting ConnState = iota
+	StateConnected
+	StateDisconnected
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// NetConfig configures a NetPort, the zero value is ready to use.
+type NetConfig struct {
+	// Dial connects to the bridge, a net.Dialer on tcp by default.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// DialTimeout bounds each connection attempt, 5s by default.
+	DialTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait between connection attempts,
+	// it doubles after every failure. 100ms and 10s by default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnStateChange is called on every change of state, with the error that
+	// caused it if any. Calls are made one at a time from the goroutine that
+	// keeps the link up, they must not block.
+	OnStateChange func(s ConnState, err error)
+	// Device is given to Open by OpenNet, "x2m200" by default.
+	Device string
+}
+
+func (c *NetConfig) defaults() {
+	if c.Dial == nil {
+		var d net.Dialer
+		c.Dial = d.DialContext
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = 10 * time.Second
+		if c.MaxBackoff < c.MinBackoff {
+			c.MaxBackoff = c.MinBackoff
+		}
+	}
+}
+
+// NetPort is a port on a raw TCP serial bridge such as ser2net. When the
+// link drops it dials again with backoff while reads and writes wait for
+// it, so a Framer on top of it carries on as if nothing happened, save
+// for the frame that was cut in half. Deadlines apply across reconnects.
+type NetPort struct {
+	addr string
+	cfg  NetConfig
+
+	mu    sync.Mutex
+	state ConnState
+	conn  net.Conn
+	// ready is closed once conn is set, lost gets the error that broke it
+	ready chan struct{}
+	lost  chan error
+	// rdl and wdl are the deadlines, wake is closed when they change so
+	// that waiters can pick them up
+	rdl, wdl time.Time
+	wake     chan struct{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// DialNetPort connects to addr, host:port of the bridge, and keeps the link
+// up until Close. The first attempt is made straight away and its error
+// returned, later ones are retried for ever.
+func DialNetPort(ctx context.Context, addr string, c NetConfig) (*NetPort, error) {
+	c.defaults()
+	p := &NetPort{
+		addr:  addr,
+		cfg:   c,
+		ready: make(chan struct{}),
+		wake:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.setState(StateConnecting, nil)
+	conn, err := p.dial(ctx)
+	if err != nil {
+		p.cancel()
+		p.setState(StateClosed, err)
+		return nil, err
+	}
+	p.connected(conn)
+	go p.run()
+	return p, nil
+}
+
+// OpenNet connects to a serial bridge with DialNetPort and returns a Framer
+// on top of it for the module c.Device.
+func OpenNet(ctx context.Context, addr string, c NetConfig, opts ...Option) (Framer, error) {
+	p, err := DialNetPort(ctx, addr, c)
+	if err != nil {
+		return nil, err
+	}
+	return Open(c.Device, p, opts...), nil
+}
+
+func (p *NetPort) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DialTimeout)
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+	return p.cfg.Dial(ctx, "tcp", p.addr)
+}
+
+func (p *NetPort) setState(s ConnState, err error) {
+	p.mu.Lock()
+	p.state = s
+	p.mu.Unlock()
+	if p.cfg.OnStateChange != nil {
+		p.cfg.OnStateChange(s, err)
+	}
+}
+
+// State returns the current state of the link.
+func (p *NetPort) State() ConnState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *NetPort) connected(conn net.Conn) {
+	p.mu.Lock()
+	p.conn = conn
+	p.lost = make(chan error, 1)
+	conn.SetReadDeadline(p.rdl)
+	conn.SetWriteDeadline(p.wdl)
+	close(p.ready)
+	p.mu.Unlock()
+	p.setState(StateConnected, nil)
+}
+
+// run waits for the link to drop and brings it back up.
+func (p *NetPort) run() {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		lost := p.lost
+		p.mu.Unlock()
+		var err error
+		select {
+		case err = <-lost:
+		case <-p.ctx.Done():
+			return
+		}
+		p.setState(StateDisconnected, err)
+
+		backoff := p.cfg.MinBackoff
+		for {
+			p.setState(StateConnecting, nil)
+			conn, err := p.dial(p.ctx)
+			if err == nil {
+				p.connected(conn)
+				break
+			}
+			if p.ctx.Err() != nil {
+				return
+			}
+			p.setState(StateDisconnected, err)
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-p.ctx.Done():
+				t.Stop()
+				return
+			}
+			if backoff *= 2; backoff > p.cfg.MaxBackoff {
+				backoff = p.cfg.MaxBackoff
+			}
+		}
+	}
+}
+
+// drop hands a broken connection back to run, unless it was already
+// replaced.
+func (p *NetPort) drop(conn net.Conn, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != conn {
+		return
+	}
+	conn.Close()
+	p.conn = nil
+	p.ready = make(chan struct{})
+	p.lost <- err
+}
+
+// wait returns the connection, waiting for it up to the read or write
+// deadline.
+func (p *NetPort) wait(write bool) (net.Conn, error) {
+	for {
+		p.mu.Lock()
+		conn, ready, wake, dl := p.conn, p.ready, p.wake, p.rdl
+		if write {
+			dl = p.wdl
+		}
+		p.mu.Unlock()
+		if p.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		if conn != nil {
+			return conn, nil
+		}
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !dl.IsZero() {
+			d := time.Until(dl)
+			if d <= 0 {
+				return nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+		select {
+		case <-ready:
+		case <-wake:
+		case <-expired:
+			return nil, os.ErrDeadlineExceeded
+		case <-p.ctx.Done():
+			return nil, net.ErrClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (p *NetPort) Read(b []byte) (int, error) {
+	for {
+		conn, err := p.wait(false)
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(b)
+		if err == nil {
+			return n, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || p.ctx.Err() != nil {
+			return n, err
+		}
+		p.drop(conn, err)
+		// the bytes read before the drop are handed over with its error,
+		// the next Read waits for the new connection
+		if n > 0 {
+			return n, err
+		}
+	}
+}
+
+// Write sends b whole on the current connection. When the link drops in
+// the middle, b is sent again from the start once it is back: the module
+// never saw the part sent before the drop, and a frame cut short is thrown
+// away at the next START.
+func (p *NetPort) Write(b []byte) (int, error) {
+	for {
+		conn, err := p.wait(true)
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Write(b)
+		if err == nil {
+			return n, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || p.ctx.Err() != nil {
+			return n, err
+		}
+		p.drop(conn, err)
+	}
+}
+
+// SetReadDeadline and SetWriteDeadline work like the ones of net.Conn and
+// carry over to the connections made after a drop.
+func (p *NetPort) SetReadDeadline(t time.Time) error {
+	return p.setDeadline(t, false)
+}
+
+func (p *NetPort) SetWriteDeadline(t time.Time) error {
+	return p.setDeadline(t, true)
+}
+
+func (p *NetPort) setDeadline(t time.Time, write bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if write {
+		p.wdl = t
+	} else {
+		p.rdl = t
+	}
+	close(p.wake)
+	p.wake = make(chan struct{})
+	if p.conn == nil {
+		return nil
+	}
+	if write {
+		return p.conn.SetWriteDeadline(t)
+	}
+	return p.conn.SetReadDeadline(t)
+}
+
+// Close stops reconnecting and closes the connection.
+func (p *NetPort) Close() error {
+	p.closeOnce.Do(func() {
+		p.cancel()
+		<-p.done
+		p.mu.Lock()
+		conn := p.conn
+		p.conn = nil
+		p.mu.Unlock()
+		if conn != nil {
+			p.closeErr = conn.Close()
+		}
+		p.setState(StateClosed, nil)
+	})
+	return p.closeErr
+}
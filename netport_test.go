@@ -0,0 +1,151 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNetPortReconnects(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	var states []ConnState
+	cfg := NetConfig{
+		Device:     "x4m300",
+		MinBackoff: time.Millisecond,
+		OnStateChange: func(s ConnState, err error) {
+			mu.Lock()
+			states = append(states, s)
+			mu.Unlock()
+		},
+	}
+	f, err := OpenNet(context.Background(), l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := driverOf(f); d != x4m300Driver {
+		t.Errorf("Expected: %s, got %s\n", x4m300Driver.name, d.name)
+	}
+
+	// the bridge sends a frame, waits for the answer and drops the link,
+	// twice
+	acks := make(chan []byte)
+	go func() {
+		defer close(acks)
+		for i := 0; i < 2; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(AppendFrame(nil, respirationPayload()))
+			got := make([]byte, len(AppendFrame(nil, []byte{ack})))
+			io.ReadFull(conn, got)
+			conn.Close()
+			acks <- got
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		fr, err := f.ReadFrame()
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", i, nil, err)
+		}
+		if !bytes.Equal(fr.Payload, respirationPayload()) {
+			t.Errorf("test %d Expected: %x, got %x\n", i, respirationPayload(), fr.Payload)
+		}
+		fr.Release()
+		f.Write([]byte{ack})
+		if got, want := <-acks, AppendFrame(nil, []byte{ack}); !bytes.Equal(got, want) {
+			t.Errorf("test %d Expected: %x, got %x\n", i, want, got)
+		}
+	}
+
+	// nothing listens any more, a read waits for the link until ctx is done
+	l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadFrameContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+
+	f.Close()
+	if _, err := f.ReadFrame(); err == nil {
+		t.Errorf("Expected: an error after Close, got %v\n", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []ConnState{StateConnecting, StateConnected, StateDisconnected, StateConnecting, StateConnected, StateDisconnected, StateConnecting}
+	if len(states) < len(expected)+1 || states[len(states)-1] != StateClosed {
+		t.Fatalf("Expected: %v ... %v, got %v\n", expected, StateClosed, states)
+	}
+	for n, s := range expected {
+		if states[n] != s {
+			t.Errorf("test %d Expected: %v, got %v\n", n, s, states[n])
+		}
+	}
+}
+
+func TestDialNetPortFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if _, err := DialNetPort(context.Background(), addr, NetConfig{}); err == nil {
+		t.Errorf("Expected: a dial error, got %v\n", err)
+	}
+}
+
+// cutConn sends the first n bytes written to it and then fails, as a link
+// that drops in the middle of a write.
+type cutConn struct {
+	net.Conn
+	n int
+}
+
+func (c *cutConn) Write(b []byte) (int, error) {
+	if len(b) <= c.n {
+		return c.Conn.Write(b)
+	}
+	n, _ := c.Conn.Write(b[:c.n])
+	return n, io.ErrClosedPipe
+}
+
+func TestNetPortWriteResends(t *testing.T) {
+	got := []chan []byte{make(chan []byte, 1), make(chan []byte, 1)}
+	conns := 0
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, bridge := net.Pipe()
+		go func(got chan<- []byte) {
+			b, _ := io.ReadAll(bridge)
+			got <- b
+		}(got[conns])
+		if conns++; conns == 1 {
+			return &cutConn{Conn: c, n: 3}, nil
+		}
+		return c, nil
+	}
+	p, err := DialNetPort(context.Background(), "bridge", NetConfig{Dial: dial, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []byte("0123456789")
+	n, err := p.Write(b)
+	if n != len(b) || err != nil {
+		t.Errorf("Expected: %v %v, got %v %v\n", len(b), nil, n, err)
+	}
+	p.Close()
+	// the new connection gets all of it
+	if first, second := <-got[0], <-got[1]; !bytes.Equal(first, b[:3]) || !bytes.Equal(second, b) {
+		t.Errorf("Expected: %q then %q, got %q then %q\n", b[:3], b, first, second)
+	}
+}
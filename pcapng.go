@@ -0,0 +1,167 @@
+package xethru
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// PcapngLinkType is the link type of the interface in pcapng files,
+// LINKTYPE_USER0. Tell Wireshark how to decode it under
+// Preferences > Protocols > DLT_USER.
+const PcapngLinkType = 147
+
+// pcapng block types and options, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngSectionHeader   = 0x0a0d0d0a
+	pcapngInterface       = 0x00000001
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1a2b3c4d
+	pcapngOptEnd          = 0
+	pcapngOptIfName       = 2
+	pcapngOptIfDesc       = 3
+	pcapngOptIfTsresol    = 9
+	pcapngOptEpbFlags     = 2
+	pcapngFlagInbound     = 1
+	pcapngFlagOutbound    = 2
+	pcapngSnapLen         = 0
+	pcapngNanosecondTsres = 9
+)
+
+// PcapngWriter writes frames as a pcapng file with one interface. Packets
+// hold the frame as it was on the wire, from START to END, stamped with
+// the host time in nanoseconds and flagged inbound or outbound.
+// It is safe for concurrent use.
+type PcapngWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewPcapngWriter writes the section and interface headers to w. The name
+// shows up as the interface name in Wireshark, the device path for
+// instance.
+func NewPcapngWriter(w io.Writer, name string) (*PcapngWriter, error) {
+	p := &PcapngWriter{w: w}
+
+	b := pcapngBlockStart(nil, pcapngSectionHeader)
+	b = binary.LittleEndian.AppendUint32(b, pcapngByteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, 1) // major
+	b = binary.LittleEndian.AppendUint16(b, 0) // minor
+	// section length is not known
+	b = binary.LittleEndian.AppendUint64(b, 0xffffffffffffffff)
+	b = pcapngBlockEnd(b, 0)
+
+	start := len(b)
+	b = pcapngBlockStart(b, pcapngInterface)
+	b = binary.LittleEndian.AppendUint16(b, PcapngLinkType)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, pcapngSnapLen)
+	if name != "" {
+		b = pcapngOption(b, pcapngOptIfName, []byte(name))
+	}
+	b = pcapngOption(b, pcapngOptIfDesc, []byte("XeThru serial frames"))
+	b = pcapngOption(b, pcapngOptIfTsresol, []byte{pcapngNanosecondTsres})
+	b = pcapngOption(b, pcapngOptEnd, nil)
+	b = pcapngBlockEnd(b, start)
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteFrame writes a raw frame seen at time t.
+func (p *PcapngWriter) WriteFrame(t time.Time, d Direction, raw []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ts := uint64(t.UnixNano())
+	flags := uint32(pcapngFlagInbound)
+	if d == Outbound {
+		flags = pcapngFlagOutbound
+	}
+	var f [4]byte
+	binary.LittleEndian.PutUint32(f[:], flags)
+
+	b := pcapngBlockStart(p.buf[:0], pcapngEnhancedPacket)
+	b = binary.LittleEndian.AppendUint32(b, 0) // interface
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(raw)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(raw)))
+	b = pcapngPad(append(b, raw...))
+	b = pcapngOption(b, pcapngOptEpbFlags, f[:])
+	b = pcapngOption(b, pcapngOptEnd, nil)
+	b = pcapngBlockEnd(b, 0)
+	p.buf = b
+
+	_, err := p.w.Write(b)
+	return err
+}
+
+// Tap returns a Tap that writes every frame of a Framer, use it with
+// WithTap. Write errors are dropped.
+func (p *PcapngWriter) Tap() Tap {
+	return func(ev TapEvent) {
+		p.WriteFrame(ev.Time, ev.Direction, ev.Raw)
+	}
+}
+
+// CaptureToPcapng converts the capture file read from src to a pcapng file
+// written to dst. Records that are not frames are skipped.
+func CaptureToPcapng(dst io.Writer, src io.Reader, name string) error {
+	c, err := NewCaptureReader(src)
+	if err != nil {
+		return err
+	}
+	p, err := NewPcapngWriter(dst, name)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := c.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !rec.IsFrame() {
+			continue
+		}
+		if err := p.WriteFrame(rec.Time, rec.Direction(), rec.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// pcapngBlockStart appends the block type and room for the total length.
+func pcapngBlockStart(b []byte, typ uint32) []byte {
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+// pcapngBlockEnd fills in the total length of the block starting at start,
+// at its head and at its tail.
+func pcapngBlockEnd(b []byte, start int) []byte {
+	n := uint32(len(b) - start + 4)
+	binary.LittleEndian.PutUint32(b[start+4:], n)
+	return binary.LittleEndian.AppendUint32(b, n)
+}
+
+func pcapngOption(b []byte, code uint16, v []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(v)))
+	return pcapngPad(append(b, v...))
+}
+
+// pcapngPad pads b to 32 bits.
+func pcapngPad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,93 @@
+package xethru
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type pcapngBlock struct {
+	typ  uint32
+	body []byte
+}
+
+// pcapngBlocks splits a pcapng file into blocks checking both lengths.
+func pcapngBlocks(t *testing.T, b []byte) []pcapngBlock {
+	var blocks []pcapngBlock
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("short block %x\n", b)
+		}
+		n := binary.LittleEndian.Uint32(b[4:8])
+		if n%4 != 0 || int(n) > len(b) {
+			t.Fatalf("bad block length %d\n", n)
+		}
+		if tail := binary.LittleEndian.Uint32(b[n-4 : n]); tail != n {
+			t.Fatalf("Expected: %d, got %d\n", n, tail)
+		}
+		blocks = append(blocks, pcapngBlock{binary.LittleEndian.Uint32(b[0:4]), b[8 : n-4]})
+		b = b[n:]
+	}
+	return blocks
+}
+
+func TestCaptureToPcapng(t *testing.T) {
+	t0 := time.Unix(1476000000, 123456789)
+	frames := []struct {
+		t   time.Time
+		d   Direction
+		raw []byte
+	}{
+		{t0, Outbound, AppendFrame(nil, []byte{resetCmd})},
+		{t0.Add(time.Millisecond), Inbound, AppendFrame(nil, []byte{ack})},
+		{t0.Add(2 * time.Millisecond), Inbound, AppendFrame(nil, respirationPayload())},
+	}
+	var capture bytes.Buffer
+	w, _ := NewCaptureWriter(&capture)
+	for _, f := range frames {
+		w.WriteFrame(f.t, f.d, f.raw)
+	}
+	w.WriteRecord(CaptureRecord{Time: t0, Kind: CaptureKind(0x7f), Data: []byte("skipped")})
+
+	var out bytes.Buffer
+	if err := CaptureToPcapng(&out, &capture, "/dev/ttyACM0"); err != nil {
+		t.Fatal(err)
+	}
+	blocks := pcapngBlocks(t, out.Bytes())
+	if len(blocks) != 2+len(frames) {
+		t.Fatalf("Expected: %d blocks, got %d\n", 2+len(frames), len(blocks))
+	}
+	if blocks[0].typ != pcapngSectionHeader || binary.LittleEndian.Uint32(blocks[0].body) != pcapngByteOrderMagic {
+		t.Errorf("Expected: section header, got %x\n", blocks[0])
+	}
+	if blocks[1].typ != pcapngInterface || binary.LittleEndian.Uint16(blocks[1].body) != PcapngLinkType {
+		t.Errorf("Expected: interface %d, got %x\n", PcapngLinkType, blocks[1])
+	}
+	if !bytes.Contains(blocks[1].body, []byte("/dev/ttyACM0")) {
+		t.Errorf("Expected: interface name, got %x\n", blocks[1].body)
+	}
+	for n, f := range frames {
+		b := blocks[n+2]
+		if b.typ != pcapngEnhancedPacket {
+			t.Errorf("test %d Expected: %d, got %d\n", n, pcapngEnhancedPacket, b.typ)
+			continue
+		}
+		ts := uint64(binary.LittleEndian.Uint32(b.body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(b.body[8:12]))
+		if ts != uint64(f.t.UnixNano()) {
+			t.Errorf("test %d Expected: %d, got %d\n", n, f.t.UnixNano(), ts)
+		}
+		l := binary.LittleEndian.Uint32(b.body[12:16])
+		if !bytes.Equal(b.body[20:20+l], f.raw) {
+			t.Errorf("test %d Expected: %x, got %x\n", n, f.raw, b.body[20:20+l])
+		}
+		opts := b.body[20+(l+3)/4*4:]
+		flags := uint32(pcapngFlagInbound)
+		if f.d == Outbound {
+			flags = pcapngFlagOutbound
+		}
+		if code := binary.LittleEndian.Uint16(opts); code != pcapngOptEpbFlags || binary.LittleEndian.Uint32(opts[4:8]) != flags {
+			t.Errorf("test %d Expected: flags %d, got %x\n", n, flags, opts)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package xethru
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Replay speeds.
+const (
+	ReplayAsFastAsPossible = 0
+	ReplayRealTime         = 1
+)
+
+// replay is a Framer that plays back the inbound frames of a capture.
+type replay struct {
+	mu      sync.Mutex
+	c       *CaptureReader
+	closer  io.Closer
+	speed   float64
+	pending *CaptureRecord
+	// ahead holds the records read while looking for the system info and
+	// err the error that stopped it, both are handed out by readRecord
+	ahead []CaptureRecord
+	err   error
+	// info is the last system info record read
+	info *SystemInfo
+	// drv is the one of the device record of the capture
+	drv *driver
+	// first is the capture time of the first frame and start the host
+	// time it was handed out at
+	first time.Time
+	start time.Time
+	stats *linkStats
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewReplay returns a Framer that reads the inbound frames of the capture
+// in r. A speed of ReplayRealTime keeps the original timing, 10 plays back
+// ten times faster and ReplayAsFastAsPossible does not wait at all.
+// Writes are accepted and dropped, Module.SystemInfo answers with the
+// system info record of the capture. Reads return io.EOF at the end of the
+// capture. A Module parses the frames as sent by the device of the device
+// record, an X2M200 when the capture has none.
+func NewReplay(r io.Reader, speed float64) (Framer, error) {
+	c, err := NewCaptureReader(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &replay{c: c, speed: speed, drv: x2m200Driver, stats: new(linkStats), done: make(chan struct{})}
+	if closer, ok := r.(io.Closer); ok {
+		p.closer = closer
+	}
+	rec, err := c.ReadRecord()
+	switch {
+	case err != nil:
+		p.err = err
+	case rec.Kind == CaptureDevice:
+		p.drv = lookupDriver(string(rec.Data))
+	default:
+		p.ahead = append(p.ahead, rec)
+		p.keepInfo(rec)
+	}
+	return p, nil
+}
+
+// next returns the next inbound frame record.
+func (p *replay) next() (*CaptureRecord, error) {
+	if p.pending != nil {
+		return p.pending, nil
+	}
+	for {
+		rec, err := p.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Kind {
+		case CaptureInbound:
+			p.pending = &rec
+			return p.pending, nil
+		case CaptureSystemInfo:
+			p.keepInfo(rec)
+		}
+	}
+}
+
+// keepInfo keeps the system info of rec, if it is a system info record.
+func (p *replay) keepInfo(rec CaptureRecord) {
+	if rec.Kind != CaptureSystemInfo {
+		return
+	}
+	if s, err := rec.SystemInfo(); err == nil {
+		p.info = &s
+	}
+}
+
+func (p *replay) readRecord() (CaptureRecord, error) {
+	if len(p.ahead) > 0 {
+		rec := p.ahead[0]
+		p.ahead = p.ahead[1:]
+		return rec, nil
+	}
+	if p.err != nil {
+		return CaptureRecord{}, p.err
+	}
+	return p.c.ReadRecord()
+}
+
+// infoLookahead is how many records capturedSystemInfo reads ahead, a
+// recorder writes the system info after the frames of the query.
+const infoLookahead = 64
+
+// wait sleeps until the frame captured at t is due.
+func (p *replay) wait(ctx context.Context, t time.Time) error {
+	if p.start.IsZero() {
+		p.first, p.start = t, time.Now()
+		return nil
+	}
+	if p.speed <= 0 {
+		return nil
+	}
+	due := p.start.Add(time.Duration(float64(t.Sub(p.first)) / p.speed))
+	d := time.Until(due)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return io.EOF
+	}
+}
+
+func (p *replay) ReadFrameContext(ctx context.Context) (*Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return nil, io.EOF
+	default:
+	}
+	rec, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.wait(ctx, rec.Time); err != nil {
+		return nil, err
+	}
+	p.pending = nil
+
+	_, payload, err := validator(rec.Data)
+	if isProtocolError(err) {
+		p.stats.received(len(rec.Data), nil)
+		p.stats.protocolError(payload[1])
+		return nil, err
+	}
+	p.stats.received(len(rec.Data), err)
+	if err != nil {
+		return nil, err
+	}
+	f := getFrame()
+	f.buf = append(f.buf[:0], payload...)
+	f.Payload = f.buf
+	return f, nil
+}
+
+// capturedSystemInfo returns the system info of the capture, looking for
+// it in the records not played yet if none was seen.
+func (p *replay) capturedSystemInfo() (SystemInfo, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.ahead); p.info == nil && p.err == nil && i < infoLookahead; i++ {
+		rec, err := p.c.ReadRecord()
+		if err != nil {
+			p.err = err
+			break
+		}
+		p.ahead = append(p.ahead, rec)
+		p.keepInfo(rec)
+	}
+	if p.info == nil {
+		return SystemInfo{}, false
+	}
+	return *p.info, true
+}
+
+func (p *replay) driver() *driver {
+	return p.drv
+}
+
+func (p *replay) ReadFrame() (*Frame, error) {
+	return p.ReadFrameContext(context.Background())
+}
+
+func (p *replay) Read(b []byte) (int, error) {
+	return readContext(context.Background(), p, b)
+}
+
+func (p *replay) WriteFrameContext(ctx context.Context, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.stats.sent()
+	return len(AppendFrame(nil, b)), nil
+}
+
+func (p *replay) Write(b []byte) (int, error) {
+	return p.WriteFrameContext(context.Background(), b)
+}
+
+func (p *replay) Stats() FrameStats {
+	return p.stats.snapshot()
+}
+
+func (p *replay) Reset() (bool, error) {
+	return p.ResetContext(context.Background())
+}
+
+func (p *replay) ResetContext(ctx context.Context) (bool, error) {
+	return reset(ctx, p)
+}
+
+func (p *replay) Close() error {
+	p.once.Do(func() { close(p.done) })
+	if p.closer != nil {
+		return p.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,519 @@
+package xethru
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options, RFC 854, 856 and 858.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary  = 0
+	telnetSGA     = 3
+	telnetComPort = 44
+)
+
+// COM port control commands, RFC 2217. The server answers each one with
+// the same command plus 100.
+const (
+	comSetBaudRate       = 1
+	comSetDataSize       = 2
+	comSetParity         = 3
+	comSetStopSize       = 4
+	comSetControl        = 5
+	comNotifyModemState  = 7
+	comSetModemStateMask = 11
+	comPurgeData         = 12
+	comServerOffset      = 100
+)
+
+// SET-CONTROL values.
+const (
+	comControlNoFlow = 1
+	comControlDTROn  = 8
+	comControlDTROff = 9
+	comControlRTSOn  = 11
+	comControlRTSOff = 12
+)
+
+// Parity of a remote serial port.
+type Parity byte
+
+// Parity values as sent in SET-PARITY.
+const (
+	ParityNone  Parity = 1
+	ParityOdd   Parity = 2
+	ParityEven  Parity = 3
+	ParityMark  Parity = 4
+	ParitySpace Parity = 5
+)
+
+// StopBits of a remote serial port.
+type StopBits byte
+
+// Stop bits as sent in SET-STOPSIZE.
+const (
+	StopBits1   StopBits = 1
+	StopBits2   StopBits = 2
+	StopBits1_5 StopBits = 3
+)
+
+// Modem state bits reported by the server.
+const (
+	ModemCTS = 0x10
+	ModemDSR = 0x20
+	ModemRI  = 0x40
+	ModemDCD = 0x80
+)
+
+// ErrNoComPortControl is returned when the server refuses the COM port
+// control option.
+var ErrNoComPortControl = errors.New("rfc2217: server refused com port control")
+
+// rfc2217Buffered bounds the data held for a reader that does not keep up,
+// older bytes are dropped past it.
+const rfc2217Buffered = 1 << 16
+
+// RFC2217Config configures the remote serial port, the zero value is
+// DefaultBaudRate 8N1.
+type RFC2217Config struct {
+	BaudRate int
+	DataBits int
+	Parity   Parity
+	StopBits StopBits
+	// Timeout bounds the wait for each answer of the server, 2s by default.
+	Timeout time.Duration
+	// Device is given to Open by OpenRFC2217, "x2m200" by default.
+	Device string
+}
+
+func (c *RFC2217Config) defaults() {
+	if c.BaudRate == 0 {
+		c.BaudRate = DefaultBaudRate
+	}
+	if c.DataBits == 0 {
+		c.DataBits = 8
+	}
+	if c.Parity == 0 {
+		c.Parity = ParityNone
+	}
+	if c.StopBits == 0 {
+		c.StopBits = StopBits1
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+}
+
+// RFC2217Port is a serial port on a terminal server speaking the Telnet
+// COM port control option. A goroutine reads the Telnet stream, handling
+// negotiation and server answers, and keeps the data for Read.
+type RFC2217Port struct {
+	conn net.Conn
+	cfg  RFC2217Config
+	wmu  sync.Mutex
+	// cmu lets one command at a time wait for its answer
+	cmu sync.Mutex
+
+	mu      sync.Mutex
+	data    bytes.Buffer
+	avail   chan struct{}
+	pending map[byte]chan []byte
+	comPort chan bool
+	modem   byte
+	baud    int
+	err     error
+
+	rdl  pipeDeadline
+	done chan struct{}
+	once sync.Once
+}
+
+// DialRFC2217 connects to the terminal server at addr and sets up the port.
+func DialRFC2217(ctx context.Context, addr string, c RFC2217Config) (*RFC2217Port, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewRFC2217Port(ctx, conn, c)
+}
+
+// OpenRFC2217 connects to a terminal server with DialRFC2217 and returns a
+// Framer on top of the port for the module c.Device.
+func OpenRFC2217(ctx context.Context, addr string, c RFC2217Config, opts ...Option) (Framer, error) {
+	p, err := DialRFC2217(ctx, addr, c)
+	if err != nil {
+		return nil, err
+	}
+	return Open(c.Device, p, opts...), nil
+}
+
+// NewRFC2217Port negotiates the COM port control option on conn and sets
+// the line up as c says. conn is closed if that fails.
+func NewRFC2217Port(ctx context.Context, conn net.Conn, c RFC2217Config) (*RFC2217Port, error) {
+	c.defaults()
+	p := &RFC2217Port{
+		conn:    conn,
+		cfg:     c,
+		avail:   make(chan struct{}, 1),
+		pending: make(map[byte]chan []byte),
+		comPort: make(chan bool, 1),
+		rdl:     makePipeDeadline(),
+		done:    make(chan struct{}),
+	}
+	go p.readLoop()
+
+	err := p.writeRaw([]byte{
+		telnetIAC, telnetWILL, telnetComPort,
+		telnetIAC, telnetWILL, telnetBinary,
+		telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetWILL, telnetSGA,
+		telnetIAC, telnetDO, telnetSGA,
+	})
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	select {
+	case ok := <-p.comPort:
+		if !ok {
+			p.Close()
+			return nil, ErrNoComPortControl
+		}
+	case <-ctx.Done():
+		p.Close()
+		return nil, fmt.Errorf("rfc2217: %w", ctx.Err())
+	case <-p.done:
+		p.Close()
+		return nil, p.readErr()
+	}
+
+	steps := []func(context.Context) error{
+		func(ctx context.Context) error { return p.SetBaudRateContext(ctx, c.BaudRate) },
+		func(ctx context.Context) error { return p.command(ctx, comSetDataSize, []byte{byte(c.DataBits)}) },
+		func(ctx context.Context) error { return p.command(ctx, comSetParity, []byte{byte(c.Parity)}) },
+		func(ctx context.Context) error { return p.command(ctx, comSetStopSize, []byte{byte(c.StopBits)}) },
+		func(ctx context.Context) error { return p.command(ctx, comSetControl, []byte{comControlNoFlow}) },
+		func(ctx context.Context) error { return p.command(ctx, comSetModemStateMask, []byte{0xff}) },
+	}
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// appendIAC appends b doubling any IAC byte.
+func appendIAC(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == telnetIAC {
+			dst = append(dst, telnetIAC)
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+func (p *RFC2217Port) writeRaw(b []byte) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// command sends a COM port control command and waits for the server to
+// answer it.
+func (p *RFC2217Port) command(ctx context.Context, cmd byte, value []byte) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	reply := make(chan []byte, 1)
+	p.mu.Lock()
+	p.pending[cmd] = reply
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, cmd)
+		p.mu.Unlock()
+	}()
+
+	b := []byte{telnetIAC, telnetSB, telnetComPort, cmd}
+	b = appendIAC(b, value)
+	b = append(b, telnetIAC, telnetSE)
+	if err := p.writeRaw(b); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("rfc2217 command %d: %w", cmd, ctx.Err())
+	case <-p.done:
+		return p.readErr()
+	}
+}
+
+// SetBaudRate changes the baud rate of the remote port.
+func (p *RFC2217Port) SetBaudRate(baud int) error {
+	return p.SetBaudRateContext(context.Background(), baud)
+}
+
+// SetBaudRateContext is SetBaudRate that gives up when ctx is done.
+func (p *RFC2217Port) SetBaudRateContext(ctx context.Context, baud int) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(baud))
+	if err := p.command(ctx, comSetBaudRate, b[:]); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.baud = baud
+	p.mu.Unlock()
+	return nil
+}
+
+// BaudRate returns the baud rate last set on the remote port.
+func (p *RFC2217Port) BaudRate() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.baud, nil
+}
+
+// SetDTR and SetRTS drive the modem control lines of the remote port.
+func (p *RFC2217Port) SetDTR(on bool) error {
+	v := byte(comControlDTROff)
+	if on {
+		v = comControlDTROn
+	}
+	return p.command(context.Background(), comSetControl, []byte{v})
+}
+
+func (p *RFC2217Port) SetRTS(on bool) error {
+	v := byte(comControlRTSOff)
+	if on {
+		v = comControlRTSOn
+	}
+	return p.command(context.Background(), comSetControl, []byte{v})
+}
+
+// Purge drops the data buffered by the server in both directions.
+func (p *RFC2217Port) Purge() error {
+	return p.command(context.Background(), comPurgeData, []byte{3})
+}
+
+// ModemState returns the last modem state the server reported, see the
+// Modem* bits.
+func (p *RFC2217Port) ModemState() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modem
+}
+
+// readLoop parses the Telnet stream until the connection fails.
+func (p *RFC2217Port) readLoop() {
+	r := bufio.NewReader(p.conn)
+	var err error
+	defer func() {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		p.once.Do(func() { close(p.done) })
+	}()
+	var c byte
+	for {
+		if c, err = r.ReadByte(); err != nil {
+			return
+		}
+		if c != telnetIAC {
+			p.deliver(c)
+			continue
+		}
+		if c, err = r.ReadByte(); err != nil {
+			return
+		}
+		switch c {
+		case telnetIAC:
+			p.deliver(telnetIAC)
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			var opt byte
+			if opt, err = r.ReadByte(); err != nil {
+				return
+			}
+			if err = p.negotiate(c, opt); err != nil {
+				return
+			}
+		case telnetSB:
+			var sb []byte
+			if sb, err = readSubnegotiation(r); err != nil {
+				return
+			}
+			p.subnegotiation(sb)
+		}
+	}
+}
+
+// deliver keeps a data byte for Read.
+func (p *RFC2217Port) deliver(c byte) {
+	p.mu.Lock()
+	if p.data.Len() >= rfc2217Buffered {
+		p.data.Next(1)
+	}
+	p.data.WriteByte(c)
+	p.mu.Unlock()
+	select {
+	case p.avail <- struct{}{}:
+	default:
+	}
+}
+
+// negotiate answers the options we did not ask for with a refusal, the
+// ones we asked for need no answer.
+func (p *RFC2217Port) negotiate(cmd, opt byte) error {
+	wanted := opt == telnetBinary || opt == telnetSGA || opt == telnetComPort
+	switch cmd {
+	case telnetDO:
+		if opt == telnetComPort {
+			p.comPortAnswer(true)
+		}
+		if !wanted {
+			return p.writeRaw([]byte{telnetIAC, telnetWONT, opt})
+		}
+	case telnetDONT:
+		if opt == telnetComPort {
+			p.comPortAnswer(false)
+		}
+	case telnetWILL:
+		if !wanted || opt == telnetComPort {
+			return p.writeRaw([]byte{telnetIAC, telnetDONT, opt})
+		}
+	}
+	return nil
+}
+
+func (p *RFC2217Port) comPortAnswer(ok bool) {
+	select {
+	case p.comPort <- ok:
+	default:
+	}
+}
+
+// readSubnegotiation reads up to IAC SE and returns the unescaped content.
+func readSubnegotiation(r *bufio.Reader) ([]byte, error) {
+	var sb []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c != telnetIAC {
+			sb = append(sb, c)
+			continue
+		}
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		if c == telnetSE {
+			return sb, nil
+		}
+		sb = append(sb, c)
+	}
+}
+
+func (p *RFC2217Port) subnegotiation(sb []byte) {
+	if len(sb) < 2 || sb[0] != telnetComPort || sb[1] < comServerOffset {
+		return
+	}
+	cmd, value := sb[1]-comServerOffset, sb[2:]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cmd == comNotifyModemState && len(value) > 0 {
+		p.modem = value[0]
+		return
+	}
+	if reply, ok := p.pending[cmd]; ok {
+		select {
+		case reply <- value:
+		default:
+		}
+	}
+}
+
+func (p *RFC2217Port) readErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		return net.ErrClosed
+	}
+	return p.err
+}
+
+// Read returns the data received from the remote port.
+func (p *RFC2217Port) Read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		if p.data.Len() > 0 {
+			n, _ := p.data.Read(b)
+			p.mu.Unlock()
+			return n, nil
+		}
+		p.mu.Unlock()
+		select {
+		case <-p.avail:
+		case <-p.rdl.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-p.done:
+			// hand out what came in before the connection went
+			p.mu.Lock()
+			n, _ := p.data.Read(b)
+			p.mu.Unlock()
+			if n > 0 {
+				return n, nil
+			}
+			return 0, p.readErr()
+		}
+	}
+}
+
+// Write sends b to the remote port in a single write.
+func (p *RFC2217Port) Write(b []byte) (int, error) {
+	if err := p.writeRaw(appendIAC(make([]byte, 0, len(b)+8), b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetReadDeadline and SetWriteDeadline work like the ones of net.Conn.
+func (p *RFC2217Port) SetReadDeadline(t time.Time) error {
+	p.rdl.set(t)
+	return nil
+}
+
+func (p *RFC2217Port) SetWriteDeadline(t time.Time) error {
+	return p.conn.SetWriteDeadline(t)
+}
+
+func (p *RFC2217Port) Close() error {
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
@@ -0,0 +1,179 @@
+package xethru
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rfc2217Server stands in for a terminal server, it records the settings
+// and the data it gets and answers every command.
+type rfc2217Server struct {
+	refuse bool
+
+	mu       sync.Mutex
+	conn     net.Conn
+	baud     uint32
+	settings map[byte]byte
+	controls []byte
+	data     []byte
+}
+
+func (s *rfc2217Server) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.settings = make(map[byte]byte)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conn = conn
+		s.mu.Unlock()
+		s.handle(conn)
+	}()
+	return l.Addr().String()
+}
+
+func (s *rfc2217Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if c != telnetIAC {
+			s.mu.Lock()
+			s.data = append(s.data, c)
+			s.mu.Unlock()
+			continue
+		}
+		c, _ = r.ReadByte()
+		switch c {
+		case telnetIAC:
+			s.mu.Lock()
+			s.data = append(s.data, telnetIAC)
+			s.mu.Unlock()
+		case telnetWILL:
+			opt, _ := r.ReadByte()
+			answer := byte(telnetDO)
+			if opt == telnetComPort && s.refuse {
+				answer = telnetDONT
+			}
+			conn.Write([]byte{telnetIAC, answer, opt})
+		case telnetDO:
+			opt, _ := r.ReadByte()
+			conn.Write([]byte{telnetIAC, telnetWILL, opt})
+		case telnetSB:
+			sb, err := readSubnegotiation(r)
+			if err != nil || len(sb) < 2 {
+				return
+			}
+			cmd, value := sb[1], sb[2:]
+			s.mu.Lock()
+			switch cmd {
+			case comSetBaudRate:
+				s.baud = binary.BigEndian.Uint32(value)
+			case comSetControl:
+				s.controls = append(s.controls, value[0])
+			default:
+				s.settings[cmd] = value[0]
+			}
+			s.mu.Unlock()
+			reply := []byte{telnetIAC, telnetSB, telnetComPort, cmd + comServerOffset}
+			reply = appendIAC(reply, value)
+			conn.Write(append(reply, telnetIAC, telnetSE))
+		}
+	}
+}
+
+// send writes data to the client escaping IAC.
+func (s *rfc2217Server) send(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Write(appendIAC(nil, b))
+}
+
+func TestRFC2217(t *testing.T) {
+	var s rfc2217Server
+	addr := s.serve(t)
+	cfg := RFC2217Config{BaudRate: 230400, Parity: ParityEven, StopBits: StopBits2}
+	p, err := DialRFC2217(context.Background(), addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := Open("x2m200", p)
+	defer f.Close()
+
+	s.mu.Lock()
+	if s.baud != 230400 || s.settings[comSetDataSize] != 8 || s.settings[comSetParity] != byte(ParityEven) || s.settings[comSetStopSize] != byte(StopBits2) {
+		t.Errorf("Expected: 230400 8E2, got %d %v\n", s.baud, s.settings)
+	}
+	s.mu.Unlock()
+
+	// IAC in the data has to survive both ways
+	payload := []byte{0x50, 0xff, 0xff, 0x7d, 0x00}
+	s.send(AppendFrame(nil, payload))
+	fr, err := f.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fr.Payload, payload) {
+		t.Errorf("Expected: %x, got %x\n", payload, fr.Payload)
+	}
+	fr.Release()
+
+	f.Write(payload)
+	if err := p.SetDTR(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetRTS(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetBaudRate(921600); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	want := AppendFrame(nil, payload)
+	if !bytes.Equal(s.data, want) {
+		t.Errorf("Expected: %x, got %x\n", want, s.data)
+	}
+	if controls := []byte{comControlNoFlow, comControlDTROff, comControlRTSOn}; !bytes.Equal(s.controls, controls) || s.baud != 921600 {
+		t.Errorf("Expected: %v 921600, got %v %d\n", controls, s.controls, s.baud)
+	}
+	s.mu.Unlock()
+
+	s.mu.Lock()
+	s.conn.Write([]byte{telnetIAC, telnetSB, telnetComPort, comNotifyModemState + comServerOffset, ModemCTS | ModemDSR, telnetIAC, telnetSE})
+	s.mu.Unlock()
+	for i := 0; i < 100 && p.ModemState() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if got := p.ModemState(); got != ModemCTS|ModemDSR {
+		t.Errorf("Expected: %#02x, got %#02x\n", ModemCTS|ModemDSR, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadFrameContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRFC2217Refused(t *testing.T) {
+	s := rfc2217Server{refuse: true}
+	addr := s.serve(t)
+	if _, err := DialRFC2217(context.Background(), addr, RFC2217Config{}); err != ErrNoComPortControl {
+		t.Errorf("Expected: %v, got %v\n", ErrNoComPortControl, err)
+	}
+}
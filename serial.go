@@ -0,0 +1,68 @@
+package xethru
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// DefaultBaudRate is the rate the X2M200 talks at out of the box.
+const DefaultBaudRate = 115200
+
+// ErrSerialNotSupported is returned by OpenSerial on systems without a
+// native serial port driver, use Open with a serial library there.
+var ErrSerialNotSupported = errors.New("serial ports are not supported on this system")
+
+// SerialConfig configures a serial port.
+type SerialConfig struct {
+	// BaudRate defaults to DefaultBaudRate.
+	BaudRate int
+	// Shared leaves the port open to other processes, by default it is
+	// opened exclusively so nothing else can talk to the module.
+	Shared bool
+	// Device is given to Open by OpenSerial, "x2m200" by default.
+	Device string
+}
+
+// SerialPort is a tty in raw 8N1 mode. It supports read and write
+// deadlines, so a Framer on top of it can cancel a read without leaving a
+// goroutine behind.
+type SerialPort struct {
+	f *os.File
+}
+
+// OpenSerial opens and configures the serial port at path, /dev/ttyACM0
+// for instance, and returns a Framer ready to talk to the module c.Device.
+func OpenSerial(path string, c SerialConfig, opts ...Option) (Framer, error) {
+	p, err := OpenSerialPort(path, c)
+	if err != nil {
+		return nil, err
+	}
+	return Open(c.Device, p, opts...), nil
+}
+
+func (p *SerialPort) Read(b []byte) (int, error) {
+	return p.f.Read(b)
+}
+
+func (p *SerialPort) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+func (p *SerialPort) Close() error {
+	return p.f.Close()
+}
+
+// SetReadDeadline and SetWriteDeadline work like the ones of os.File.
+func (p *SerialPort) SetReadDeadline(t time.Time) error {
+	return p.f.SetReadDeadline(t)
+}
+
+func (p *SerialPort) SetWriteDeadline(t time.Time) error {
+	return p.f.SetWriteDeadline(t)
+}
+
+// Name returns the path the port was opened with.
+func (p *SerialPort) Name() string {
+	return p.f.Name()
+}
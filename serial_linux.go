@@ -0,0 +1,138 @@
+//go:build !ppc64 && !ppc64le
+
+package xethru
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Termios bits missing from package syscall, the values are the same on
+// every Linux architecture but powerpc, which is left out.
+const (
+	termiosCBAUD   = 0x100f
+	termiosCRTSCTS = 0x80000000
+)
+
+var baudRates = map[int]uint32{
+	1200:    syscall.B1200,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	2000000: syscall.B2000000,
+	3000000: syscall.B3000000,
+}
+
+// OpenSerialPort opens the tty at path and sets it up for the module: raw
+// mode, 8 data bits, no parity, one stop bit, no flow control and reads
+// that return as soon as a byte is in. It does not use cgo.
+func OpenSerialPort(path string, c SerialConfig) (*SerialPort, error) {
+	// the fd is non blocking so that the runtime poller handles it and
+	// deadlines work
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &SerialPort{f: f}
+	if err := p.configure(c); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("serial %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *SerialPort) configure(c SerialConfig) error {
+	baud := c.BaudRate
+	if baud == 0 {
+		baud = DefaultBaudRate
+	}
+	speed, ok := baudRates[baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	if !c.Shared {
+		if err := p.ioctl(syscall.TIOCEXCL, nil); err != nil {
+			return fmt.Errorf("exclusive lock: %w", err)
+		}
+	}
+	var t syscall.Termios
+	if err := p.ioctl(syscall.TCGETS, &t); err != nil {
+		return err
+	}
+	// what cfmakeraw does
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termiosCRTSCTS
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	setSpeed(&t, speed)
+	return p.ioctl(syscall.TCSETS, &t)
+}
+
+// SetBaudRate changes the baud rate of an open port.
+func (p *SerialPort) SetBaudRate(baud int) error {
+	speed, ok := baudRates[baud]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	var t syscall.Termios
+	if err := p.ioctl(syscall.TCGETS, &t); err != nil {
+		return err
+	}
+	setSpeed(&t, speed)
+	return p.ioctl(syscall.TCSETS, &t)
+}
+
+// BaudRate returns the output baud rate the port is set to.
+func (p *SerialPort) BaudRate() (int, error) {
+	var t syscall.Termios
+	if err := p.ioctl(syscall.TCGETS, &t); err != nil {
+		return 0, err
+	}
+	for baud, speed := range baudRates {
+		if t.Cflag&termiosCBAUD == speed {
+			return baud, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown speed %#x", t.Cflag&termiosCBAUD)
+}
+
+func setSpeed(t *syscall.Termios, speed uint32) {
+	t.Cflag &^= termiosCBAUD
+	t.Cflag |= speed
+}
+
+// ioctl runs a termios ioctl on the port without taking the fd out of non
+// blocking mode, which File.Fd would do.
+func (p *SerialPort) ioctl(req uintptr, t *syscall.Termios) error {
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
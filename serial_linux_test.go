@@ -0,0 +1,110 @@
+//go:build !ppc64 && !ppc64le
+
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY returns the master side of a new pseudo terminal and the path of
+// its slave, which stands in for the module's tty.
+func openPTY(t *testing.T) (*os.File, string) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatal(errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatal(errno)
+	}
+	return m, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpenSerialPort(t *testing.T) {
+	_, path := openPTY(t)
+	cases := []struct {
+		baud int
+		want int
+	}{
+		{0, DefaultBaudRate},
+		{9600, 9600},
+		{921600, 921600},
+	}
+	for n, c := range cases {
+		p, err := OpenSerialPort(path, SerialConfig{BaudRate: c.baud})
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		var tio syscall.Termios
+		if err := p.ioctl(syscall.TCGETS, &tio); err != nil {
+			t.Fatal(err)
+		}
+		if tio.Cflag&syscall.CSIZE != syscall.CS8 || tio.Cflag&(syscall.PARENB|syscall.CSTOPB) != 0 || tio.Lflag&(syscall.ICANON|syscall.ECHO) != 0 || tio.Cc[syscall.VMIN] != 1 {
+			t.Errorf("test %d Expected: raw 8N1, got %+v\n", n, tio)
+		}
+		if baud, err := p.BaudRate(); baud != c.want || err != nil {
+			t.Errorf("test %d Expected: %d, got %d %v\n", n, c.want, baud, err)
+		}
+		p.Close()
+	}
+	if _, err := OpenSerialPort(path, SerialConfig{BaudRate: 1234}); err == nil {
+		t.Errorf("Expected: unsupported baud rate, got %v\n", err)
+	}
+}
+
+func TestOpenSerial(t *testing.T) {
+	m, path := openPTY(t)
+	f, err := OpenSerial(path, SerialConfig{Device: "x4m200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if d := driverOf(f); d != x4m200Driver {
+		t.Errorf("Expected: %s, got %s\n", x4m200Driver.name, d.name)
+	}
+
+	// from the module to the host
+	m.Write(AppendFrame(nil, respirationPayload()))
+	fr, err := f.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fr.Payload, respirationPayload()) {
+		t.Errorf("Expected: %x, got %x\n", respirationPayload(), fr.Payload)
+	}
+	fr.Release()
+
+	// from the host to the module, raw mode must not touch the bytes
+	cmd := []byte{0x0d, 0x0a, 0x7d, 0x03, 0x11, 0x13}
+	if _, err := f.Write(cmd); err != nil {
+		t.Fatal(err)
+	}
+	want := AppendFrame(nil, cmd)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(m, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected: %x, got %x\n", want, got)
+	}
+
+	// the tty supports deadlines so reads can be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadFrameContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected: %v, got %v\n", context.DeadlineExceeded, err)
+	}
+}
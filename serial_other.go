@@ -0,0 +1,18 @@
+//go:build !linux || ppc64 || ppc64le
+
+package xethru
+
+// OpenSerialPort is only implemented on Linux.
+func OpenSerialPort(path string, c SerialConfig) (*SerialPort, error) {
+	return nil, ErrSerialNotSupported
+}
+
+// SetBaudRate is only implemented on Linux.
+func (p *SerialPort) SetBaudRate(baud int) error {
+	return ErrSerialNotSupported
+}
+
+// BaudRate is only implemented on Linux.
+func (p *SerialPort) BaudRate() (int, error) {
+	return 0, ErrSerialNotSupported
+}
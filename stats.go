@@ -0,0 +1,128 @@
+package xethru
+
+import (
+	"sync/atomic"
+)
+
+// FrameStats is a snapshot of the link level counters of a Framer.
+type FrameStats struct {
+	// FramesReceived and FramesSent count valid frames in each direction,
+	// including frames carrying a protocol error.
+	FramesReceived uint64
+	FramesSent     uint64
+	// BytesDiscarded counts bytes thrown away while looking for a START,
+	// including partial frames abandoned because a new START turned up.
+	BytesDiscarded uint64
+	// Resyncs counts the times the reader had to skip bytes to get back to
+	// the START of a frame, after junk on the line or a broken frame.
+	Resyncs uint64
+	// FramesTooLong counts frames dropped for being longer than the
+	// maximum frame size, their bytes are counted in BytesDiscarded.
+	FramesTooLong uint64
+	// CRCFailures counts frames dropped because of a bad checksum.
+	CRCFailures uint64
+	// EscapeErrors counts frames dropped because ESC was followed by
+	// something other than a flag byte.
+	EscapeErrors uint64
+	// ProtocolErrors counts error frames sent by the device, by error code.
+	ProtocolErrors map[byte]uint64
+	// MaxFrameSize is the largest frame seen on the wire in bytes, including
+	// START, END and escapes.
+	MaxFrameSize int
+}
+
+// linkStats holds the live counters, it is updated by the reader and writer
+// and may be read at any time.
+type linkStats struct {
+	framesReceived uint64
+	framesSent     uint64
+	bytesDiscarded uint64
+	resyncs        uint64
+	framesTooLong  uint64
+	crcFailures    uint64
+	escapeErrors   uint64
+	maxFrameSize   int64
+	protocolErrors [256]uint64
+}
+
+func (s *linkStats) discarded(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	atomic.AddUint64(&s.bytesDiscarded, uint64(n))
+}
+
+func (s *linkStats) resynced() {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.resyncs, 1)
+}
+
+func (s *linkStats) tooLong() {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.framesTooLong, 1)
+}
+
+// received records a frame of n bytes on the wire, and why it was dropped
+// if it was.
+func (s *linkStats) received(n int, err error) {
+	if s == nil {
+		return
+	}
+	for {
+		max := atomic.LoadInt64(&s.maxFrameSize)
+		if int64(n) <= max || atomic.CompareAndSwapInt64(&s.maxFrameSize, max, int64(n)) {
+			break
+		}
+	}
+	switch err {
+	case nil:
+		atomic.AddUint64(&s.framesReceived, 1)
+	case ErrBadCRC:
+		atomic.AddUint64(&s.crcFailures, 1)
+	case ErrBadEscape:
+		atomic.AddUint64(&s.escapeErrors, 1)
+	}
+}
+
+func (s *linkStats) sent() {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.framesSent, 1)
+}
+
+func (s *linkStats) protocolError(code byte) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.protocolErrors[code], 1)
+}
+
+func (s *linkStats) snapshot() FrameStats {
+	if s == nil {
+		return FrameStats{}
+	}
+	st := FrameStats{
+		FramesReceived: atomic.LoadUint64(&s.framesReceived),
+		FramesSent:     atomic.LoadUint64(&s.framesSent),
+		BytesDiscarded: atomic.LoadUint64(&s.bytesDiscarded),
+		Resyncs:        atomic.LoadUint64(&s.resyncs),
+		FramesTooLong:  atomic.LoadUint64(&s.framesTooLong),
+		CRCFailures:    atomic.LoadUint64(&s.crcFailures),
+		EscapeErrors:   atomic.LoadUint64(&s.escapeErrors),
+		MaxFrameSize:   int(atomic.LoadInt64(&s.maxFrameSize)),
+	}
+	for code := range s.protocolErrors {
+		if n := atomic.LoadUint64(&s.protocolErrors[code]); n > 0 {
+			if st.ProtocolErrors == nil {
+				st.ProtocolErrors = make(map[byte]uint64)
+			}
+			st.ProtocolErrors[byte(code)] = n
+		}
+	}
+	return st
+}
@@ -0,0 +1,79 @@
+package xethru
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFrameStats(t *testing.T) {
+	var in []byte
+	in = append(in, 0x00, 0x01, 0x02)                    // 3 bytes of junk
+	in = AppendFrame(in, []byte{0x01, 0x02, 0x03})       // good, 7 bytes
+	in = append(in, 0x7d, 0x01, 0x02, 0x03, 0x71, 0x7e)  // bad crc
+	in = append(in, 0x7d, 0x01, 0x7f, 0x02, 0x03, 0x7d)  // bad escape, then restart
+	in = AppendFrame(in, []byte{0x20, 0x02})             // protocol error crc failed
+	in = AppendFrame(in, []byte{0x20, 0x02})             // protocol error crc failed
+	in = AppendFrame(in, []byte{0x7e, 0x7e, 0x02, 0x7e}) // good, 10 bytes
+
+	var out bytes.Buffer
+	x := newX2M200Frame(&out, bytes.NewReader(in), nil)
+	x.Write([]byte{0x01})
+	x.Write([]byte{0x02})
+	b := make([]byte, 64)
+	for {
+		_, err := x.Read(b)
+		if err == io.EOF {
+			break
+		}
+	}
+
+	expected := FrameStats{
+		FramesReceived: 4,
+		FramesSent:     2,
+		// the junk, then "03" left over from the bad escape frame and its
+		// trailing START, abandoned when the next frame starts
+		BytesDiscarded: 3 + 2,
+		// the junk, the rest of the bad escape frame and its trailing START
+		Resyncs:        3,
+		CRCFailures:    1,
+		EscapeErrors:   1,
+		ProtocolErrors: map[byte]uint64{0x02: 2},
+		MaxFrameSize:   10,
+	}
+	if got := x.Stats(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %+v, got %+v\n", expected, got)
+	}
+}
+
+func TestFrameStatsConcurrent(t *testing.T) {
+	r, w := io.Pipe()
+	x := newX2M200Frame(ioutil.Discard, r, nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			f, err := x.ReadFrame()
+			if err == io.EOF || err == io.ErrClosedPipe {
+				return
+			}
+			f.Release()
+		}
+	}()
+	e := NewFrameEncoder(w)
+	for i := 0; i < 100; i++ {
+		e.Encode(respirationPayload())
+		x.Write([]byte{0x01})
+		x.Stats()
+	}
+	w.Close()
+	wg.Wait()
+	st := x.Stats()
+	if st.FramesReceived != 100 || st.FramesSent != 100 {
+		t.Errorf("Expected: %d %d, got %d %d\n", 100, 100, st.FramesReceived, st.FramesSent)
+	}
+}
@@ -9,17 +9,23 @@ import (
 
 var (
 	_statusNameToValue = map[string]status{
-		"respApp":    respApp,
-		"sleepApp":   sleepApp,
-		"basebandAP": basebandAP,
-		"basebandIQ": basebandIQ,
+		"respApp":            respApp,
+		"sleepApp":           sleepApp,
+		"basebandAP":         basebandAP,
+		"basebandIQ":         basebandIQ,
+		"respMovingList":     respMovingList,
+		"presenceSingle":     presenceSingle,
+		"presenceMovingList": presenceMovingList,
 	}
 
 	_statusValueToName = map[status]string{
-		respApp:    "respApp",
-		sleepApp:   "sleepApp",
-		basebandAP: "basebandAP",
-		basebandIQ: "basebandIQ",
+		respApp:            "respApp",
+		sleepApp:           "sleepApp",
+		basebandAP:         "basebandAP",
+		basebandIQ:         "basebandIQ",
+		respMovingList:     "respMovingList",
+		presenceSingle:     "presenceSingle",
+		presenceMovingList: "presenceMovingList",
 	}
 )
 
@@ -27,10 +33,13 @@ func init() {
 	var v status
 	if _, ok := interface{}(v).(fmt.Stringer); ok {
 		_statusNameToValue = map[string]status{
-			interface{}(respApp).(fmt.Stringer).String():    respApp,
-			interface{}(sleepApp).(fmt.Stringer).String():   sleepApp,
-			interface{}(basebandAP).(fmt.Stringer).String(): basebandAP,
-			interface{}(basebandIQ).(fmt.Stringer).String(): basebandIQ,
+			interface{}(respApp).(fmt.Stringer).String():            respApp,
+			interface{}(sleepApp).(fmt.Stringer).String():           sleepApp,
+			interface{}(basebandAP).(fmt.Stringer).String():         basebandAP,
+			interface{}(basebandIQ).(fmt.Stringer).String():         basebandIQ,
+			interface{}(respMovingList).(fmt.Stringer).String():     respMovingList,
+			interface{}(presenceSingle).(fmt.Stringer).String():     presenceSingle,
+			interface{}(presenceMovingList).(fmt.Stringer).String(): presenceMovingList,
 		}
 	}
 }
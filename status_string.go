@@ -8,12 +8,16 @@ const (
 	_status_name_0 = "basebandIQbasebandAP"
 	_status_name_1 = "sleepApp"
 	_status_name_2 = "respApp"
+	_status_name_3 = "respMovingList"
+	_status_name_4 = "presenceSinglepresenceMovingList"
 )
 
 var (
 	_status_index_0 = [...]uint8{0, 10, 20}
 	_status_index_1 = [...]uint8{0, 8}
 	_status_index_2 = [...]uint8{0, 7}
+	_status_index_3 = [...]uint8{0, 14}
+	_status_index_4 = [...]uint8{0, 14, 32}
 )
 
 func (i status) String() string {
@@ -25,6 +29,11 @@ func (i status) String() string {
 		return _status_name_1
 	case i == 594935334:
 		return _status_name_2
+	case i == 1628060416:
+		return _status_name_3
+	case 1916533278 <= i && i <= 1916533279:
+		i -= 1916533278
+		return _status_name_4[_status_index_4[i]:_status_index_4[i+1]]
 	default:
 		return fmt.Sprintf("status(%d)", i)
 	}
@@ -0,0 +1,279 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SupervisorConfig configures a Supervisor, Open is the only field that
+// has to be set.
+type SupervisorConfig struct {
+	// Open opens the port and returns a Framer on it, it is called every
+	// time the link has to be brought up.
+	Open func(ctx context.Context) (Framer, error)
+	// Mode is given to NewModule, respiration by default.
+	Mode string
+	// The configuration sent after every Reset and Load, in this order.
+	// The detection zone is left alone while DetectionZoneEnd is 0,
+	// Baseband is given to Enable.
+	LEDMode            ledMode
+	DetectionZoneStart float64
+	DetectionZoneEnd   float64
+	Sensitivity        int
+	Baseband           string
+	// Verify reads the configuration back before Enable and gives up on
+	// the connection when the module does not have it, see Module.Verify.
+	Verify bool
+	// FramePeriod is the time between two data messages, the link is
+	// taken for dead after SilentPeriods of them with no data. 1s and 5 by
+	// default.
+	FramePeriod   time.Duration
+	SilentPeriods int
+	// PingInterval is the time between two pings making sure the module
+	// still answers, 5s by default.
+	PingInterval time.Duration
+	// BootTimeout bounds the wait for the module to be ready after a Reset,
+	// 5s by default.
+	BootTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait between two attempts to
+	// bring the link up, it doubles after every failure. 100ms and 10s by
+	// default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnStateChange is called on every change of state with the error
+	// that caused it if any, from the goroutine calling Run. It must not
+	// block.
+	OnStateChange func(s ConnState, err error)
+}
+
+func (c *SupervisorConfig) defaults() {
+	if c.Mode == "" {
+		c.Mode = "respiration"
+	}
+	if c.FramePeriod <= 0 {
+		c.FramePeriod = time.Second
+	}
+	if c.SilentPeriods <= 0 {
+		c.SilentPeriods = 5
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = 5 * time.Second
+	}
+	if c.BootTimeout <= 0 {
+		c.BootTimeout = 5 * time.Second
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = 10 * time.Second
+		if c.MaxBackoff < c.MinBackoff {
+			c.MaxBackoff = c.MinBackoff
+		}
+	}
+}
+
+// Supervisor keeps a module streaming. It opens the port, resets and
+// configures the module and runs it, and when the link dies, on a read
+// error, silence or a failed ping, or the module reboots, it starts over.
+type Supervisor struct {
+	cfg SupervisorConfig
+
+	mu    sync.Mutex
+	state ConnState
+}
+
+// NewSupervisor returns a Supervisor, nothing is opened until Run.
+func NewSupervisor(c SupervisorConfig) *Supervisor {
+	c.defaults()
+	return &Supervisor{cfg: c, state: StateDisconnected}
+}
+
+// State returns the current state of the link.
+func (s *Supervisor) State() ConnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Supervisor) setState(st ConnState, err error) {
+	s.mu.Lock()
+	s.state = st
+	s.mu.Unlock()
+	if s.cfg.OnStateChange != nil {
+		s.cfg.OnStateChange(st, err)
+	}
+}
+
+// Run streams parsed data to stream, across reconnects, until ctx is done
+// or the module has no app for Mode.
+func (s *Supervisor) Run(ctx context.Context, stream chan interface{}) error {
+	backoff := s.cfg.MinBackoff
+	for {
+		s.setState(StateConnecting, nil)
+		m, err := s.connect(ctx)
+		if err == nil {
+			s.setState(StateConnected, nil)
+			backoff = s.cfg.MinBackoff
+			err = s.stream(ctx, m, stream)
+		}
+		if ctx.Err() != nil {
+			s.setState(StateClosed, nil)
+			return ctx.Err()
+		}
+		// no reconnect makes up for it
+		if errors.Is(err, ErrUnknownMode) {
+			s.setState(StateClosed, err)
+			return err
+		}
+		s.setState(StateDisconnected, err)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			s.setState(StateClosed, nil)
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+// connect opens the port and replays the configuration.
+func (s *Supervisor) connect(ctx context.Context) (*Module, error) {
+	f, err := s.cfg.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	m, err := s.configure(ctx, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *Supervisor) configure(ctx context.Context, f Framer) (*Module, error) {
+	if _, err := reset(ctx, f); err != nil {
+		return nil, err
+	}
+	if err := s.waitReady(ctx, f); err != nil {
+		return nil, err
+	}
+	m := NewModule(f, s.cfg.Mode)
+	m.LEDMode = s.cfg.LEDMode
+	if err := s.setup(ctx, m); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// setup loads the app and sends it the configuration.
+func (s *Supervisor) setup(ctx context.Context, m *Module) error {
+	if err := m.LoadContext(ctx); err != nil {
+		return err
+	}
+	if err := m.SetLEDModeContext(ctx); err != nil {
+		return err
+	}
+	if s.cfg.DetectionZoneEnd != 0 {
+		if err := m.SetDetectionZoneContext(ctx, s.cfg.DetectionZoneStart, s.cfg.DetectionZoneEnd); err != nil {
+			return err
+		}
+	}
+	if err := m.SetSensitivityContext(ctx, s.cfg.Sensitivity); err != nil {
+		return err
+	}
+	if s.cfg.Verify {
+		if err := m.VerifyContext(ctx); err != nil {
+			return err
+		}
+	}
+	return m.EnableContext(ctx, s.cfg.Baseband)
+}
+
+// waitReady pings the module until it is done booting.
+func (s *Supervisor) waitReady(ctx context.Context, f Framer) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.BootTimeout)
+	defer cancel()
+	for {
+		pctx, pcancel := context.WithTimeout(ctx, DefaultCommandTimeout)
+		ready, err := ping(pctx, f)
+		pcancel()
+		if ready {
+			return nil
+		}
+		if ctx.Err() != nil {
+			if err == nil {
+				err = ctx.Err()
+			}
+			return fmt.Errorf("wait for ready: %w", err)
+		}
+		if err == nil {
+			// booting, give it a moment
+			t := time.NewTimer(DefaultCommandTimeout / 5)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
+		}
+	}
+}
+
+// stream runs m until the link dies, then closes it and its Framer.
+func (s *Supervisor) stream(ctx context.Context, m *Module, out chan interface{}) error {
+	rctx, cancel := context.WithCancel(ctx)
+	in := make(chan interface{})
+	ended := make(chan error, 1)
+	go func() { ended <- m.RunContext(rctx, in) }()
+	defer func() {
+		cancel()
+		m.f.Close()
+		<-ended
+		m.Close()
+	}()
+
+	check := time.NewTicker(s.cfg.FramePeriod)
+	defer check.Stop()
+	pings := time.NewTicker(s.cfg.PingInterval)
+	defer pings.Stop()
+	silence := time.Duration(s.cfg.SilentPeriods) * s.cfg.FramePeriod
+	last := time.Now()
+	for {
+		select {
+		case v := <-in:
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// time spent waiting on a slow consumer is not silence
+			last = time.Now()
+		case err := <-ended:
+			ended <- err
+			return fmt.Errorf("read: %w", err)
+		case <-check.C:
+			if time.Since(last) > silence {
+				return ErrSilent
+			}
+		case <-pings.C:
+			pctx, pcancel := context.WithTimeout(ctx, DefaultCommandTimeout)
+			ready, err := m.PingContext(pctx)
+			pcancel()
+			if err != nil {
+				return fmt.Errorf("ping: %w", err)
+			}
+			if !ready {
+				return ErrNotReady
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respirationFrames streams n respiration frames, or keeps going when n is
+// 0.
+func respirationFrames(n uint32) func(i uint32) []byte {
+	return func(i uint32) []byte {
+		if n != 0 && i > n {
+			return nil
+		}
+		return respirationCounter(i)
+	}
+}
+
+// loads counts the connections configured.
+func loads(devs []*fakeDevice) int {
+	n := 0
+	for _, d := range devs {
+		for _, c := range d.commands() {
+			if c[0] == x2m200LoadModule {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSupervisor(t *testing.T) {
+	var devs []*fakeDevice
+	var mu sync.Mutex
+	var states []ConnState
+	var errs []error
+	opens := 0
+	var lastDev net.Conn
+	s := NewSupervisor(SupervisorConfig{
+		Open: func(ctx context.Context) (Framer, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			opens++
+			host, dev := net.Pipe()
+			lastDev = dev
+			d := &fakeDevice{frame: respirationFrames(0)}
+			switch opens {
+			case 1:
+				// browns out after a few frames
+				d.frame = respirationFrames(5)
+			case 2:
+				// does not even open
+				dev.Close()
+				return nil, io.ErrUnexpectedEOF
+			}
+			devs = append(devs, d)
+			go d.serve(dev)
+			return Open("x2m200", host), nil
+		},
+		FramePeriod:   5 * time.Millisecond,
+		SilentPeriods: 4,
+		MinBackoff:    time.Millisecond,
+		OnStateChange: func(s ConnState, err error) {
+			mu.Lock()
+			states = append(states, s)
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- s.Run(ctx, stream) }()
+
+	next := func() uint32 {
+		select {
+		case v := <-stream:
+			return v.(Respiration).Counter
+		case <-ctx.Done():
+			t.Fatalf("Expected: data, got %v\n", ctx.Err())
+		}
+		return 0
+	}
+	for i := 1; i <= 5; i++ {
+		if c := next(); c != uint32(i) {
+			t.Fatalf("Expected: counter %d, got %d\n", i, c)
+		}
+	}
+	// back after the silence and the failed open
+	if c := next(); c != 1 {
+		t.Fatalf("Expected: counter %d, got %d\n", 1, c)
+	}
+	// unplugged
+	mu.Lock()
+	lastDev.Close()
+	mu.Unlock()
+	for next() != 1 {
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected: %v, got %v\n", context.Canceled, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []ConnState{
+		StateConnecting, StateConnected, StateDisconnected,
+		StateConnecting, StateDisconnected,
+		StateConnecting, StateConnected, StateDisconnected,
+		StateConnecting, StateConnected, StateClosed,
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("Expected: %v, got %v\n", expected, states)
+	}
+	if !errors.Is(errs[2], ErrSilent) || !errors.Is(errs[4], io.ErrUnexpectedEOF) || errs[7] == nil {
+		t.Errorf("Expected: %v %v and a read error, got %v %v %v\n", ErrSilent, io.ErrUnexpectedEOF, errs[2], errs[4], errs[7])
+	}
+	// the configuration is replayed on every connection
+	if n := loads(devs); n != 3 {
+		t.Errorf("Expected: %d loads, got %d\n", 3, n)
+	}
+}
+
+func TestSupervisorReboot(t *testing.T) {
+	var mu sync.Mutex
+	var devs []*fakeDevice
+	var errs []error
+	s := NewSupervisor(SupervisorConfig{
+		Open: func(ctx context.Context) (Framer, error) {
+			d := &fakeDevice{frame: respirationFrames(0)}
+			mu.Lock()
+			devs = append(devs, d)
+			mu.Unlock()
+			return d.open("x2m200"), nil
+		},
+		PingInterval: 5 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+		OnStateChange: func(s ConnState, err error) {
+			if s == StateDisconnected {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- s.Run(ctx, stream) }()
+
+	<-stream
+	// streams on after rebooting, but answers pings as not ready
+	mu.Lock()
+	devs[0].reboot()
+	mu.Unlock()
+	for {
+		mu.Lock()
+		n := len(devs)
+		mu.Unlock()
+		select {
+		case <-stream:
+		case <-ctx.Done():
+			t.Fatalf("Expected: a new connection, got %v\n", ctx.Err())
+		}
+		// data once there is a second device comes from it, configured
+		if n == 2 {
+			break
+		}
+	}
+	cancel()
+	for {
+		select {
+		case <-stream:
+			continue
+		case <-done:
+		}
+		break
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) == 0 || !errors.Is(errs[0], ErrNotReady) {
+		t.Errorf("Expected: %v, got %v\n", ErrNotReady, errs)
+	}
+	if n := loads(devs); n != 2 {
+		t.Errorf("Expected: %d loads, got %d\n", 2, n)
+	}
+}
+
+func TestSupervisorUnknownMode(t *testing.T) {
+	s := NewSupervisor(SupervisorConfig{
+		Open: func(ctx context.Context) (Framer, error) {
+			return new(fakeDevice).open("x2m200"), nil
+		},
+		Mode: "presence",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Run(ctx, make(chan interface{})); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("Expected: %v, got %v\n", ErrUnknownMode, err)
+	}
+}
@@ -0,0 +1,109 @@
+package xethru
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Direction tells which way a frame travelled.
+type Direction int
+
+// Frame directions seen from the host.
+const (
+	Inbound  Direction = iota // from the module to the host
+	Outbound                  // from the host to the module
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// TapEvent describes one frame going through a Framer.
+// Raw and Payload are only valid for the duration of the callback, copy
+// them to keep them.
+type TapEvent struct {
+	Direction Direction
+	Time      time.Time
+	// Raw is the frame as it was on the wire, START to END with escapes.
+	Raw []byte
+	// Payload is the decoded data, nil when the frame could not be decoded.
+	Payload []byte
+	// Err is set for frames that failed to decode, carried a protocol
+	// error, or could not be written.
+	Err error
+}
+
+// Tap is called for every frame read or written by a Framer. It runs on
+// the reading or writing goroutine so it must not block.
+type Tap func(TapEvent)
+
+// Option configures a Framer created by Open.
+type Option func(*frameOptions)
+
+type frameOptions struct {
+	taps         []Tap
+	maxFrameSize int
+	events       func(CommandEvent)
+}
+
+// WithMaxFrameSize bounds the size of inbound frames in bytes on the wire,
+// DefaultMaxFrameSize when not given. Longer frames are dropped with
+// ErrFrameTooLong and the reader skips to the next START, so noise or a
+// wrong baud rate cannot grow the buffers without limit. A size of 0 or
+// less removes the limit.
+func WithMaxFrameSize(n int) Option {
+	return func(o *frameOptions) {
+		o.maxFrameSize = n
+	}
+}
+
+// WithTap registers a tap, it may be given more than once.
+func WithTap(t Tap) Option {
+	return func(o *frameOptions) {
+		o.taps = append(o.taps, t)
+	}
+}
+
+func (o *frameOptions) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+func (o *frameOptions) tap(d Direction, raw, payload []byte, err error) {
+	if len(o.taps) == 0 {
+		return
+	}
+	ev := TapEvent{
+		Direction: d,
+		Time:      time.Now(),
+		Raw:       raw,
+		Payload:   payload,
+		Err:       err,
+	}
+	for _, t := range o.taps {
+		t(ev)
+	}
+}
+
+// LogTap returns a Tap that logs every frame in hex to l, or to the standard
+// logger when l is nil.
+func LogTap(l *log.Logger) Tap {
+	if l == nil {
+		l = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return func(ev TapEvent) {
+		if ev.Err != nil {
+			l.Printf("%-3s % x (%v)", ev.Direction, ev.Raw, ev.Err)
+			return
+		}
+		l.Printf("%-3s % x", ev.Direction, ev.Raw)
+	}
+}
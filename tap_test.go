@@ -0,0 +1,74 @@
+package xethru
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// rwc joins a reader and a writer into a port.
+type rwc struct {
+	io.Reader
+	io.Writer
+}
+
+func (rwc) Close() error { return nil }
+
+func TestTap(t *testing.T) {
+	var in []byte
+	in = AppendFrame(in, []byte{0x10})
+	in = append(in, 0x7d, 0x01, 0x02, 0x03, 0x71, 0x7e)
+	in = AppendFrame(in, []byte{errorByte, ProtocolErrorInvalidAppID})
+	port := rwc{bytes.NewReader(in), new(bytes.Buffer)}
+
+	var first, second []TapEvent
+	keep := func(evs *[]TapEvent) Tap {
+		return func(ev TapEvent) {
+			ev.Raw = append([]byte(nil), ev.Raw...)
+			ev.Payload = append([]byte(nil), ev.Payload...)
+			*evs = append(*evs, ev)
+		}
+	}
+	x := Open("x2m200", port, WithTap(keep(&first)), WithTap(keep(&second)))
+	x.Write([]byte{0x7e})
+	b := make([]byte, 16)
+	for i := 0; i < 3; i++ {
+		x.Read(b)
+	}
+
+	expected := []TapEvent{
+		{Direction: Outbound, Raw: AppendFrame(nil, []byte{0x7e}), Payload: []byte{0x7e}},
+		{Direction: Inbound, Raw: AppendFrame(nil, []byte{0x10}), Payload: []byte{0x10}},
+		{Direction: Inbound, Raw: []byte{0x7d, 0x01, 0x02, 0x03, 0x71, 0x7e}, Payload: []byte{}, Err: ErrBadCRC},
+		{Direction: Inbound, Raw: AppendFrame(nil, []byte{errorByte, ProtocolErrorInvalidAppID}), Payload: []byte{errorByte, ProtocolErrorInvalidAppID}, Err: ErrInvalidAppID},
+	}
+	if len(first) != len(expected) || len(second) != len(expected) {
+		t.Fatalf("Expected: %d events, got %d and %d\n", len(expected), len(first), len(second))
+	}
+	for n, c := range expected {
+		ev := first[n]
+		if ev.Direction != c.Direction || !bytes.Equal(ev.Raw, c.Raw) || !bytes.Equal(ev.Payload, c.Payload) || !errors.Is(ev.Err, c.Err) {
+			t.Errorf("test %d Expected: %v %x %x %v, got %v %x %x %v\n", n, c.Direction, c.Raw, c.Payload, c.Err, ev.Direction, ev.Raw, ev.Payload, ev.Err)
+		}
+		if ev.Time.IsZero() {
+			t.Errorf("test %d missing timestamp\n", n)
+		}
+	}
+}
+
+func TestLogTap(t *testing.T) {
+	var out bytes.Buffer
+	tap := LogTap(log.New(&out, "", 0))
+	tap(TapEvent{Direction: Outbound, Raw: []byte{0x7d, 0x22, 0x5f, 0x7e}})
+	tap(TapEvent{Direction: Inbound, Raw: []byte{0x7d, 0x7e}, Err: ErrFrameTooShort})
+	expected := "out 7d 22 5f 7e\nin  7d 7e (frame not long enough)\n"
+	if out.String() != expected {
+		t.Errorf("Expected: %q, got %q\n", expected, out.String())
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("Expected: %d lines\n", 2)
+	}
+}
@@ -0,0 +1,207 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default policy for the module commands.
+const (
+	DefaultCommandTimeout = 500 * time.Millisecond
+	DefaultCommandRetries = 3
+)
+
+// ErrResend is returned by a Command's Reply to have the command sent again,
+// it counts against the retries.
+var ErrResend = errors.New("send the command again")
+
+// Command is one request to the module and what to make of the replies.
+type Command[T any] struct {
+	// Name is used in errors and events.
+	Name    string
+	Payload []byte
+	// Reply is handed each frame read while the command waits, data frames
+	// too when the Framer is not behind a Demux. It reports done once the
+	// expected reply has come, the frame is released when it returns.
+	Reply func(p []byte) (v T, done bool, err error)
+	// Timeout bounds each attempt, 0 leaves it to the context.
+	Timeout time.Duration
+	// Retries is how many more times the command is sent after an attempt
+	// times out, the module reports a bad CRC or Reply asks for it.
+	Retries int
+}
+
+// CommandEventKind tells what happened to a command.
+type CommandEventKind int
+
+// Command events, in the order they can happen.
+const (
+	CommandSent    CommandEventKind = iota // an attempt was written
+	CommandTimeout                         // an attempt got no reply in time
+	CommandRetry                           // the command is about to be sent again
+	CommandDone                            // the expected reply came
+	CommandFailed                          // the command gave up
+)
+
+func (k CommandEventKind) String() string {
+	switch k {
+	case CommandSent:
+		return "sent"
+	case CommandTimeout:
+		return "timeout"
+	case CommandRetry:
+		return "retry"
+	case CommandDone:
+		return "done"
+	case CommandFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("CommandEventKind(%d)", int(k))
+}
+
+// CommandEvent describes a step of a command, see WithCommandEvents.
+type CommandEvent struct {
+	Kind    CommandEventKind
+	Command string
+	// Attempt counts from 1, a retry event carries the attempt to come.
+	Attempt int
+	Time    time.Time
+	// Elapsed is the time since the command was first sent.
+	Elapsed time.Duration
+	// Err is why an attempt is retried or the command failed.
+	Err error
+}
+
+// WithCommandEvents has fn called for every command event on the Framer and
+// on Modules using it. It runs on the goroutine sending the command.
+func WithCommandEvents(fn func(CommandEvent)) Option {
+	return func(o *frameOptions) {
+		o.events = fn
+	}
+}
+
+// link is what a command goes over: a Framer read by nothing else, or a
+// Module sharing one with Run through its Demux.
+type link interface {
+	writeCommand(ctx context.Context, p []byte) error
+	nextReply(ctx context.Context) (*Frame, error)
+	commandEvents() func(CommandEvent)
+}
+
+type framerLink struct {
+	f Framer
+}
+
+func (l framerLink) writeCommand(ctx context.Context, p []byte) error {
+	_, err := l.f.WriteFrameContext(ctx, p)
+	return err
+}
+
+// nextReply skips frames that did not decode, error frames from the
+// module are returned.
+func (l framerLink) nextReply(ctx context.Context) (*Frame, error) {
+	for {
+		f, err := l.f.ReadFrameContext(ctx)
+		if err != nil && isFramingError(err) && !isProtocolError(err) {
+			continue
+		}
+		return f, err
+	}
+}
+
+func (l framerLink) commandEvents() func(CommandEvent) {
+	return commandEvents(l.f)
+}
+
+// commandEvents returns the callback given to Open, if f came from it.
+func commandEvents(f Framer) func(CommandEvent) {
+	if e, ok := f.(interface{ commandEvents() func(CommandEvent) }); ok {
+		return e.commandEvents()
+	}
+	return nil
+}
+
+// Transact sends c on f and waits for its reply, sending it again as the
+// retry policy allows. f must not be read by anything else meanwhile, use
+// the Module commands while a Module is running.
+func Transact[T any](ctx context.Context, f Framer, c Command[T]) (T, error) {
+	return transact(ctx, framerLink{f}, c)
+}
+
+func transact[T any](ctx context.Context, l link, c Command[T]) (T, error) {
+	var zero T
+	emit := l.commandEvents()
+	start := time.Now()
+	event := func(k CommandEventKind, attempt int, err error) {
+		if emit == nil {
+			return
+		}
+		now := time.Now()
+		emit(CommandEvent{Kind: k, Command: c.Name, Attempt: attempt, Time: now, Elapsed: now.Sub(start), Err: err})
+	}
+	for attempt := 1; ; attempt++ {
+		v, err := c.attempt(ctx, l, func() { event(CommandSent, attempt, nil) })
+		if err == nil {
+			event(CommandDone, attempt, nil)
+			return v, nil
+		}
+		retry := false
+		switch {
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		case err == ErrCommandTimeout:
+			event(CommandTimeout, attempt, err)
+			retry = true
+		case errors.Is(err, ErrResend), errors.Is(err, ErrCommandCRCFailed):
+			retry = true
+		}
+		if !retry || attempt > c.Retries {
+			if retry && attempt > 1 {
+				err = fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+			}
+			event(CommandFailed, attempt, err)
+			return zero, err
+		}
+		event(CommandRetry, attempt+1, err)
+	}
+}
+
+// attempt sends the command once and reads replies until Reply is done or
+// the attempt times out.
+func (c *Command[T]) attempt(ctx context.Context, l link, sent func()) (T, error) {
+	var zero T
+	actx := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	timedOut := func(err error) error {
+		if ctx.Err() == nil && actx.Err() != nil {
+			return ErrCommandTimeout
+		}
+		return err
+	}
+	if err := l.writeCommand(actx, c.Payload); err != nil {
+		return zero, timedOut(err)
+	}
+	sent()
+	for {
+		f, err := l.nextReply(actx)
+		if err != nil {
+			return zero, timedOut(err)
+		}
+		v, done, err := c.Reply(f.Payload)
+		f.Release()
+		if err != nil || done {
+			return v, err
+		}
+	}
+}
+
+// expectAck waits for the ack, skipping anything else.
+func expectAck(p []byte) (struct{}, bool, error) {
+	return struct{}{}, len(p) > 0 && p[0] == ack, nil
+}
@@ -0,0 +1,84 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransact(t *testing.T) {
+	cases := []struct {
+		reply    func(n int, p []byte) []byte
+		err      error
+		attempts int
+		kinds    []CommandEventKind
+	}{
+		// the third attempt is acked
+		{func(n int, p []byte) []byte {
+			if n < 2 {
+				return nil
+			}
+			return []byte{ack}
+		}, nil, 3, []CommandEventKind{CommandSent, CommandTimeout, CommandRetry, CommandSent, CommandTimeout, CommandRetry, CommandSent, CommandDone}},
+		// a bad CRC is retried straight away
+		{func(n int, p []byte) []byte {
+			if n == 0 {
+				return []byte{errorByte, ProtocolErrorCRCFailed}
+			}
+			return []byte{ack}
+		}, nil, 2, []CommandEventKind{CommandSent, CommandRetry, CommandSent, CommandDone}},
+		{func(n int, p []byte) []byte { return nil }, ErrCommandTimeout, 3, nil},
+		{func(n int, p []byte) []byte { return []byte{errorByte, ProtocolErrorCRCFailed} }, ErrCommandCRCFailed, 3, nil},
+		// only link errors are worth another go
+		{func(n int, p []byte) []byte { return []byte{errorByte, ProtocolErrorNotRecognised} }, ErrCommandNotRecognised, 1, []CommandEventKind{CommandSent, CommandFailed}},
+		// data frames are skipped
+		{func(n int, p []byte) []byte { return respirationPayload() }, ErrCommandTimeout, 3, nil},
+	}
+	for n, c := range cases {
+		var events []CommandEvent
+		x := (&fakeDevice{reply: c.reply}).open("x2m200", WithCommandEvents(func(e CommandEvent) { events = append(events, e) }))
+
+		_, err := Transact(context.Background(), x, Command[struct{}]{
+			Name:    "test",
+			Payload: []byte{0x42},
+			Reply:   expectAck,
+			Timeout: 20 * time.Millisecond,
+			Retries: 2,
+		})
+		x.Close()
+		if !errors.Is(err, c.err) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		if last := events[len(events)-1]; last.Attempt != c.attempts {
+			t.Errorf("test %d Expected: %d attempts, got %d\n", n, c.attempts, last.Attempt)
+		}
+		if c.kinds == nil {
+			continue
+		}
+		var kinds []CommandEventKind
+		for _, e := range events {
+			kinds = append(kinds, e.Kind)
+		}
+		if !reflect.DeepEqual(kinds, c.kinds) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.kinds, kinds)
+		}
+	}
+}
+
+func TestLoadGivesUp(t *testing.T) {
+	// a module that keeps rebooting never acks the load
+	d := &fakeDevice{reply: func(int, []byte) []byte { return []byte{systemMesg, systemReady} }}
+	m := d.module(t, "respiration")
+	m.Timeout = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.LoadContext(ctx); !errors.Is(err, ErrNoAck) {
+		t.Errorf("Expected: %v, got %v\n", ErrNoAck, err)
+	}
+	if loads := len(d.commands()); loads != DefaultCommandRetries+1 {
+		t.Errorf("Expected: %d loads, got %d\n", DefaultCommandRetries+1, loads)
+	}
+}
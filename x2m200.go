@@ -18,44 +18,95 @@
 package xethru
 
 import (
-	"bufio"
-	"errors"
+	"context"
 	"io"
+	"sync"
+	"time"
 )
 
 type x2m200Frame struct {
-	w io.Writer
-	r *bufio.Reader
-	c io.Closer
-	// frameBuffer []byte
+	w     *FrameEncoder
+	r     *FrameDecoder
+	c     io.Closer
+	stats *linkStats
+	// rd and wd are set when the port supports deadlines
+	rd        readDeadliner
+	wd        writeDeadliner
+	pump      readPump
+	closeOnce sync.Once
+	opts      frameOptions
+	// drv is the module family given to Open
+	drv *driver
 }
 
-type protocolError byte
-
-const (
-	notReconsied protocolError = 0x01
-	crcFailed    protocolError = 0x02
-	invaidAppID  protocolError = 0x03
-)
+func newX2M200Frame(w io.Writer, r io.Reader, c io.Closer, opts ...Option) *x2m200Frame {
+	stats := new(linkStats)
+	x := &x2m200Frame{c: c, stats: stats}
+	x.opts.maxFrameSize = DefaultMaxFrameSize
+	x.opts.apply(opts)
+	x.pump.done = make(chan struct{})
+	taps := len(x.opts.taps) > 0
+	if w != nil {
+		x.w = NewFrameEncoder(w)
+		if taps {
+			x.w.tap = func(raw, p []byte, err error) {
+				x.opts.tap(Outbound, raw, p, err)
+			}
+		}
+		if wd, ok := w.(writeDeadliner); ok && wd.SetWriteDeadline(time.Time{}) == nil {
+			x.wd = wd
+		}
+	}
+	if r != nil {
+		x.r = NewFrameDecoder(r)
+		x.r.stats = stats
+		x.r.keepRaw = taps
+		x.r.SetMaxFrameSize(x.opts.maxFrameSize)
+		if rd, ok := r.(readDeadliner); ok && rd.SetReadDeadline(time.Time{}) == nil {
+			x.rd = rd
+		}
+	}
+	return x
+}
 
 func (x *x2m200Frame) Close() error {
+	x.closeOnce.Do(func() { close(x.pump.done) })
+	if x.c == nil {
+		return nil
+	}
 	return x.c.Close()
 }
 
+// Write frames p, escaping any flag bytes in the data and the CRC, and
+// returns the number of bytes written on the wire.
 func (x *x2m200Frame) Write(p []byte) (n int, err error) {
+	n, err = x.w.Encode(p)
+	if err == nil {
+		x.stats.sent()
+	}
+	return n, err
+}
 
-	p = append(p[:0], append([]byte{startByte}, p[0:]...)...)
-	crc := checksum(&p)
-	// not quite correct but works most of the time but need to ignor endByte that are not at end.
-	for k := 0; k < len(p); k++ {
-		if p[k] == endByte {
-			p = append(p[:k], append([]byte{escByte}, p[k:]...)...)
-			k++
-		}
+// Stats returns a snapshot of the link counters, it is safe to call while
+// another goroutine is reading or writing.
+func (x *x2m200Frame) Stats() FrameStats {
+	return x.stats.snapshot()
+}
+
+func (x *x2m200Frame) driver() *driver {
+	if x.drv == nil {
+		return x2m200Driver
 	}
-	p = append(p, crc)
-	p = append(p, endByte)
-	return x.w.Write(p)
+	return x.drv
+}
+
+// port returns what was given to Open.
+func (x *x2m200Frame) port() io.Closer {
+	return x.c
+}
+
+func (x *x2m200Frame) commandEvents() func(CommandEvent) {
+	return x.opts.events
 }
 
 // Flow Control bytes
@@ -68,118 +119,74 @@ const (
 )
 
 func (x *x2m200Frame) Read(b []byte) (n int, err error) {
-	var s []byte
-	header, err := x.r.Peek(1)
+	f, err := x.ReadFrame()
 	if err != nil {
-		return 0, io.EOF
+		return 0, err
 	}
-	if header[0] == startByte {
-		s, err = x.r.ReadBytes(endByte)
-		if err != nil {
-			return 0, err
-		}
-		ok, p, verr := validator(s)
-		// log.Println(verr)
-		if ok && verr != nil {
-			// log.Println(verr)
-			return 0, verr
-		}
+	n = copy(b, f.Payload)
+	f.Release()
+	return n, nil
+}
 
-		for !ok {
-			// scan to next endByte
-			s2, err := x.r.ReadBytes(endByte)
-			if err != nil && verr != errPacketNotLongEnough {
-				return 0, verr
-			}
-			s = append(s, s2...)
-			ok, p, _ = validator(s)
-		}
-		if ok {
-			n = copy(b, p)
-			return n, nil
+// ReadFrame decodes the next frame in place into a pooled buffer, it does
+// not allocate once the pool is warm. The caller must Release the frame.
+func (x *x2m200Frame) ReadFrame() (*Frame, error) {
+	return x.ReadFrameContext(context.Background())
+}
+
+func (x *x2m200Frame) readFrame() (*Frame, error) {
+	f := getFrame()
+	err := f.decode(x.r)
+	if err == nil {
+		if len(f.Payload) > 1 && f.Payload[0] == errorByte {
+			x.stats.protocolError(f.Payload[1])
 		}
+		err = errorFrame(f.Payload)
 	}
-	return 0, errPacketNoStartByte
-	// return 0, nil
+	if len(x.r.raw) > 0 && (err == nil || isFramingError(err)) {
+		x.opts.tap(Inbound, x.r.raw, f.Payload, err)
+	}
+	if err != nil {
+		f.Release()
+		return nil, err
+	}
+	return f, nil
 }
 
+// validator decodes a single raw frame including the START and END bytes.
 func validator(b []byte) (bool, []byte, error) {
-	// var k []byte
-	// k = append(k, b...)
-
-	const (
-		wait = iota
-		startAfterStart
-		inMessage
-		afterEsc
-		done
-	)
-	var buf []byte
-	state := wait
-	for k, v := range b {
-		switch state {
-		case wait:
-			if v == startByte {
-				state = inMessage
-				buf = append(buf, v)
-			}
-		case inMessage:
-			if k == len(b)-1 {
-				state = done
-			} else if v == escByte {
-				state = afterEsc
-			} else {
-				state = inMessage
-				buf = append(buf, v)
-			}
-		case afterEsc:
-			if v == endByte && k == len(b)-1 {
-				return false, nil, errPacketNotLongEnough
-			}
-			state = inMessage
-			buf = append(buf, v)
-		}
+	var u unstuffer
+	k := 0
+	for k < len(b) && b[k] != startByte {
+		k++
 	}
-	var crcByte byte
-	n := len(buf)
-	crcByte, buf = buf[n-1], buf[:n-1]
-	n--
-
-	crc := checksum(&buf)
-
-	if crcByte != crc {
-		return false, nil, errPacketBadCRC
+	if k == len(b) {
+		return false, nil, ErrNoStartByte
 	}
-
-	buf = buf[:0+copy(buf[0:], buf[1:])]
-	n--
-
-	if n > 1 {
-		if buf[0] == errorByte {
-			pError := protocolError(buf[1])
-			switch pError {
-			case notReconsied:
-				return true, buf, errProtocolErrorNotReconsied
-			case crcFailed:
-				return true, buf, errProtocolErrorCRCfailed
-			case invaidAppID:
-				return true, buf, errProtocolErrorInvaidAppID
+	u.start()
+	for _, v := range b[k+1:] {
+		done, err := u.feed(v)
+		if err != nil {
+			return false, nil, err
+		}
+		if done {
+			buf, err := u.payload()
+			if err != nil {
+				return false, nil, err
 			}
+			return true, buf, errorFrame(buf)
 		}
 	}
-	// log.Println("returning nil")
-	// log.Printf("%#0x\n", buf)
-	return true, buf, nil
+	return false, nil, ErrFrameTooShort
 }
 
-var (
-	errPacketNotLongEnough       = errors.New("not long enough")
-	errPacketNoStartByte         = errors.New("no startbyte")
-	errPacketBadCRC              = errors.New("failed checksum")
-	errProtocolErrorNotReconsied = errors.New("protocol error command not recognised")
-	errProtocolErrorCRCfailed    = errors.New("protocol error command bad crc")
-	errProtocolErrorInvaidAppID  = errors.New("protocol error invalid app id")
-)
+// errorFrame returns the protocol error carried by an error frame.
+func errorFrame(buf []byte) error {
+	if len(buf) > 1 && buf[0] == errorByte {
+		return &ProtocolError{Code: buf[1]}
+	}
+	return nil
+}
 
 // Calculated by XOR’ing all bytes from <START> + [Data].
 // Note that the CRC is done after escape bytes is removed. This
@@ -191,5 +198,3 @@ func checksum(p *[]byte) byte {
 	}
 	return crc
 }
-
-var errChecksumInvalidPacketSTART = errors.New("invalid packet missing start")
@@ -0,0 +1,148 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// x2m200NoiseMap manages the noise map the app keeps of the static
+// reflections in the room.
+// Example: <Start> + <XTS_SPC_MOD_NOISEMAP> + <XTS_SPCN_STORE> + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
+const (
+	x2m200NoiseMap           = 0x65
+	x2m200NoiseMapStore      = 0x02
+	x2m200NoiseMapLoad       = 0x03
+	x2m200NoiseMapInitialize = 0x04
+	x2m200NoiseMapSetControl = 0x10
+	x2m200NoiseMapGetControl = 0x11
+	x2m200NoiseMapDelete     = 0x13
+)
+
+// NoiseMapControl tells the app how to use the noise map, the flags may be
+// combined.
+type NoiseMapControl uint32
+
+// Noise map control flags.
+const (
+	// NoiseMapEnable uses the noise map, stored or learnt.
+	NoiseMapEnable NoiseMapControl = 1 << 0
+	// NoiseMapAdaptive keeps updating the noise map while running.
+	NoiseMapAdaptive NoiseMapControl = 1 << 1
+	// NoiseMapInitOnStart learns a new noise map every time the app
+	// starts instead of loading the stored one.
+	NoiseMapInitOnStart NoiseMapControl = 1 << 2
+)
+
+func (c NoiseMapControl) String() string {
+	var s []string
+	for _, f := range []struct {
+		c    NoiseMapControl
+		name string
+	}{
+		{NoiseMapEnable, "enable"},
+		{NoiseMapAdaptive, "adaptive"},
+		{NoiseMapInitOnStart, "init on start"},
+	} {
+		if c&f.c != 0 {
+			s = append(s, f.name)
+			c &^= f.c
+		}
+	}
+	if c != 0 {
+		s = append(s, fmt.Sprintf("%#x", uint32(c)))
+	}
+	if len(s) == 0 {
+		return "off"
+	}
+	return strings.Join(s, "|")
+}
+
+// StoreNoiseMap saves the noise map in use to the module's flash.
+func (r *Module) StoreNoiseMap() error {
+	return r.StoreNoiseMapContext(context.Background())
+}
+
+// StoreNoiseMapContext is StoreNoiseMap that gives up when ctx is done.
+func (r *Module) StoreNoiseMapContext(ctx context.Context) error {
+	return r.noiseMap(ctx, "store noise map", x2m200NoiseMapStore)
+}
+
+// LoadNoiseMap replaces the noise map in use with the stored one.
+func (r *Module) LoadNoiseMap() error {
+	return r.LoadNoiseMapContext(context.Background())
+}
+
+// LoadNoiseMapContext is LoadNoiseMap that gives up when ctx is done.
+func (r *Module) LoadNoiseMapContext(ctx context.Context) error {
+	return r.noiseMap(ctx, "load noise map", x2m200NoiseMapLoad)
+}
+
+// DeleteNoiseMap erases the stored noise map.
+func (r *Module) DeleteNoiseMap() error {
+	return r.DeleteNoiseMapContext(context.Background())
+}
+
+// DeleteNoiseMapContext is DeleteNoiseMap that gives up when ctx is done.
+func (r *Module) DeleteNoiseMapContext(ctx context.Context) error {
+	return r.noiseMap(ctx, "delete noise map", x2m200NoiseMapDelete)
+}
+
+// RelearnNoiseMap throws the noise map in use away and learns a new one
+// from what the module sees now, the room should be empty meanwhile. Use
+// StoreNoiseMap to keep it.
+func (r *Module) RelearnNoiseMap() error {
+	return r.RelearnNoiseMapContext(context.Background())
+}
+
+// RelearnNoiseMapContext is RelearnNoiseMap that gives up when ctx is
+// done.
+func (r *Module) RelearnNoiseMapContext(ctx context.Context) error {
+	return r.noiseMap(ctx, "relearn noise map", x2m200NoiseMapInitialize)
+}
+
+func (r *Module) noiseMap(ctx context.Context, name string, code byte) error {
+	if err := r.command(ctx, name, []byte{x2m200NoiseMap, code}, expectAck); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// SetNoiseMapControl sets how the app uses the noise map from the next
+// time it starts.
+// Example: <Start> + <XTS_SPC_MOD_NOISEMAP> + <XTS_SPCN_SETCONTROL> + [Control(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
+func (r *Module) SetNoiseMapControl(c NoiseMapControl) error {
+	return r.SetNoiseMapControlContext(context.Background(), c)
+}
+
+// SetNoiseMapControlContext is SetNoiseMapControl that gives up when ctx
+// is done.
+func (r *Module) SetNoiseMapControlContext(ctx context.Context, c NoiseMapControl) error {
+	p := []byte{x2m200NoiseMap, x2m200NoiseMapSetControl, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(p[2:], uint32(c))
+	if err := r.command(ctx, "set noise map control", p, expectAck); err != nil {
+		return fmt.Errorf("set noise map control %v: %w", c, err)
+	}
+	return nil
+}
+
+// GetNoiseMapControl asks the module how the app uses the noise map.
+// Example: <Start> + <XTS_SPC_MOD_NOISEMAP> + <XTS_SPCN_GETCONTROL> + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_REPLY> + <XTS_SPC_MOD_NOISEMAP> + <XTS_SPCN_GETCONTROL> + [Control(i)] + <CRC> + <End>
+func (r *Module) GetNoiseMapControl() (NoiseMapControl, error) {
+	return r.GetNoiseMapControlContext(context.Background())
+}
+
+// GetNoiseMapControlContext is GetNoiseMapControl that gives up when ctx
+// is done.
+func (r *Module) GetNoiseMapControlContext(ctx context.Context) (NoiseMapControl, error) {
+	p := []byte{x2m200NoiseMap, x2m200NoiseMapGetControl}
+	v, err := query(ctx, r, "get noise map control", p, expectReply(p, 4))
+	if err != nil {
+		return 0, fmt.Errorf("get noise map control: %w", err)
+	}
+	return NoiseMapControl(binary.LittleEndian.Uint32(v)), nil
+}
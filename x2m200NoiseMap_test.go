@@ -0,0 +1,51 @@
+package xethru
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNoiseMap(t *testing.T) {
+	cases := []struct {
+		fn    func(m *Module, ctx context.Context) error
+		cmd   []byte
+		reply []byte
+		err   error
+	}{
+		{(*Module).StoreNoiseMapContext, []byte{0x65, 0x02}, []byte{ack}, nil},
+		{(*Module).LoadNoiseMapContext, []byte{0x65, 0x03}, []byte{errorByte, ProtocolErrorNotRecognised}, ErrCommandNotRecognised},
+		{(*Module).DeleteNoiseMapContext, []byte{0x65, 0x13}, []byte{ack}, nil},
+		{(*Module).RelearnNoiseMapContext, []byte{0x65, 0x04}, nil, ErrNoAck},
+		{func(m *Module, ctx context.Context) error {
+			return m.SetNoiseMapControlContext(ctx, NoiseMapEnable|NoiseMapAdaptive)
+		}, []byte{0x65, 0x10, 0x03, 0, 0, 0}, []byte{ack}, nil},
+	}
+	for n, c := range cases {
+		d := &fakeDevice{reply: func(int, []byte) []byte { return c.reply }}
+		m := d.module(t, "respiration")
+		m.Timeout, m.Retries = 20*time.Millisecond, 0
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := c.fn(m, ctx); !errors.Is(err, c.err) || (c.err == nil) != (err == nil) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		if cmds := d.commands(); len(cmds) != 1 || string(cmds[0]) != string(c.cmd) {
+			t.Errorf("test %d Expected: % x, got % x\n", n, c.cmd, cmds)
+		}
+		cancel()
+	}
+}
+
+func TestGetNoiseMapControl(t *testing.T) {
+	m := (&fakeDevice{reply: func(int, []byte) []byte {
+		return []byte{x2m200Reply, x2m200NoiseMap, x2m200NoiseMapGetControl, 0x05, 0, 0, 0}
+	}}).module(t, "respiration")
+	c, err := m.GetNoiseMapControl()
+	if c != NoiseMapEnable|NoiseMapInitOnStart || err != nil {
+		t.Errorf("Expected: %v %v, got %v %v\n", NoiseMapEnable|NoiseMapInitOnStart, nil, c, err)
+	}
+	if s := c.String(); s != "enable|init on start" {
+		t.Errorf("Expected: %q, got %q\n", "enable|init on start", s)
+	}
+}
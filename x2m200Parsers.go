@@ -2,7 +2,6 @@ package xethru
 
 import (
 	"encoding/binary"
-	"errors"
 	"math"
 	"time"
 )
@@ -77,13 +76,35 @@ type SystemMessage struct {
 	Message string
 }
 
+// parse turns a frame payload into a message, any error is a *ParseError.
+// Payloads without a parser are returned as a copy of the raw bytes.
 func parse(b []byte) (interface{}, error) {
+	return parseWith(b, parseMessage)
+}
+
+// parseWith is parse for the messages of any module.
+func parseWith(b []byte, parseMessage func(b []byte) (interface{}, error)) (interface{}, error) {
+	v, err := parseMessage(b)
+	if err != nil {
+		pe := newParseError(b, err)
+		if err == ErrParseNotImplemented {
+			return pe.Payload, pe
+		}
+		return v, pe
+	}
+	return v, nil
+}
+
+func parseMessage(b []byte) (interface{}, error) {
 	// log.Printf("%02x\n", b)
 	if len(b) == 0 {
-		return nil, errNoData
+		return nil, ErrNoData
 	}
 	switch b[0] {
 	case appDataByte:
+		if len(b) < 2 {
+			return nil, ErrPayloadTooShort
+		}
 		switch b[1] {
 		case respirationStartByte:
 			resp, err := parseRespiration(b)
@@ -95,37 +116,46 @@ func parse(b []byte) (interface{}, error) {
 		case basebandIQStartByte:
 			return parseBaseBandIQ(b)
 		default:
-			return b, errParseNotImplemented
+			return nil, ErrParseNotImplemented
 		}
 	case systemMesg:
+		if len(b) < 2 {
+			return nil, ErrPayloadTooShort
+		}
 		switch b[1] {
 		case systemBooting:
 			return SystemMessage{Message: "System Still booting"}, nil
 		case systemReady:
 			return SystemMessage{Message: "System Ready"}, nil
 		default:
-			return b, errParseNotImplemented
+			return nil, ErrParseNotImplemented
 		}
 	case ack:
 		return SystemMessage{Message: "Command Ack'ed"}, nil
 
 	default:
-		return b, errParseNotImplemented
+		return nil, ErrParseNotImplemented
 	}
 	// return nil, fmt.Errorf("something went wrong: %#02x\n", b)
 }
 
-var (
-	errParseNotImplemented = errors.New("Parser not implemented")
-	errNoData              = errors.New("no data to parse")
-)
+// checkSize makes sure b is exactly n bytes long.
+func checkSize(b []byte, n int) error {
+	if len(b) < n {
+		return ErrPayloadTooShort
+	}
+	if len(b) > n {
+		return ErrPayloadTooLong
+	}
+	return nil
+}
 
 const respsize = 29
 
 func parseRespiration(b []byte) (Respiration, error) {
-	// Check to make sure respiration data is long enough
-	if len(b) != respsize {
-		return Respiration{}, errParseRespDataNotEnoughBytes
+	// Check to make sure respiration data is the right size
+	if err := checkSize(b, respsize); err != nil {
+		return Respiration{}, err
 	}
 	data := Respiration{}
 	data.Time = time.Now().UnixNano()
@@ -141,16 +171,12 @@ func parseRespiration(b []byte) (Respiration, error) {
 	return data, nil
 }
 
-var (
-	errParseRespDataNotEnoughBytes = errors.New("response does not contain enough bytes")
-)
-
 const sleepsize = 33
 
 func parseSleep(b []byte) (Sleep, error) {
 	// Make sure we have enough bytes to parse packet without panic
-	if len(b) != sleepsize {
-		return Sleep{}, errParseSleepDataNotEnoughBytes
+	if err := checkSize(b, sleepsize); err != nil {
+		return Sleep{}, err
 	}
 	data := Sleep{}
 	data.Time = time.Now().UnixNano()
@@ -166,16 +192,12 @@ func parseSleep(b []byte) (Sleep, error) {
 	return data, nil
 }
 
-var (
-	errParseSleepDataNotEnoughBytes = errors.New("response does not contain enough bytes")
-)
-
 const apheadersize = 29
 
 func parseBaseBandAP(b []byte) (BaseBandAmpPhase, error) {
 	// Make sure we have enough bytes to parse header without panic
 	if len(b) < apheadersize {
-		return BaseBandAmpPhase{}, errParseBaseBandAPNotEnoughBytes
+		return BaseBandAmpPhase{}, ErrPayloadTooShort
 	}
 	var ap BaseBandAmpPhase
 	ap.Time = time.Now().UnixNano()
@@ -187,33 +209,21 @@ func parseBaseBandAP(b []byte) (BaseBandAmpPhase, error) {
 	ap.CarrierFreq = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[21:25])))
 	ap.RangeOffset = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[25:29])))
 
-	if len(b) < int(iqheadersize+uint32(ap.Bins)) {
-		return ap, errParseBaseBandAPIncompletePacket
-	}
-
-	for i := apheadersize; i < int((ap.Bins*4)+apheadersize); i += 4 {
-		amplitude := float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i : i+4])))
-		ap.Amplitude = append(ap.Amplitude, amplitude)
+	if err := checkBins(b, apheadersize, ap.Bins); err != nil {
+		return ap, err
 	}
 
-	for i := int(apheadersize + 4*ap.Bins); i < int((ap.Bins*8)+apheadersize); i += 4 {
-		phase := float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i : i+4])))
-		ap.Phase = append(ap.Phase, phase)
-	}
+	ap.Amplitude = float32s(b[apheadersize:], ap.Bins)
+	ap.Phase = float32s(b[apheadersize+4*int(ap.Bins):], ap.Bins)
 	return ap, nil
 }
 
-var (
-	errParseBaseBandAPNotEnoughBytes   = errors.New("baseband data does contain enough bytes")
-	errParseBaseBandAPIncompletePacket = errors.New("baseband data does contain a full packet of data")
-)
-
 const iqheadersize = 29
 
 func parseBaseBandIQ(b []byte) (BaseBandIQ, error) {
 	// Make sure we have enough bytes to parse header without panic
 	if len(b) < iqheadersize {
-		return BaseBandIQ{}, errParseBaseBandIQNotEnoughBytes
+		return BaseBandIQ{}, ErrPayloadTooShort
 	}
 
 	var iq BaseBandIQ
@@ -227,25 +237,43 @@ func parseBaseBandIQ(b []byte) (BaseBandIQ, error) {
 	iq.CarrierFreq = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[21:25])))
 	iq.RangeOffset = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[25:29])))
 
-	if len(b) < int(iqheadersize+uint32(iq.Bins)) {
-		return iq, errParseBaseBandIQIncompletePacket
-	}
-
-	for i := iqheadersize; i < int((iq.Bins*4)+iqheadersize); i += 4 {
-		sigi := float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i : i+4])))
-		iq.SigI = append(iq.SigI, sigi)
-	}
-
-	for i := int(iqheadersize + 4*iq.Bins); i < int((iq.Bins*8)+iqheadersize); i += 4 {
-		sigq := float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i : i+4])))
-		iq.SigQ = append(iq.SigQ, sigq)
+	if err := checkBins(b, iqheadersize, iq.Bins); err != nil {
+		return iq, err
 	}
 
+	iq.SigI = float32s(b[iqheadersize:], iq.Bins)
+	iq.SigQ = float32s(b[iqheadersize+4*int(iq.Bins):], iq.Bins)
 	return iq, nil
+}
 
+// maxBins is far more range bins than a module sends, anything above it is
+// a corrupt header.
+const maxBins = 4096
+
+// checkBins makes sure a baseband payload holds exactly two blocks of bins
+// float32 after its header.
+func checkBins(b []byte, header int, bins uint32) error {
+	if bins > maxBins {
+		return ErrTooManyBins
+	}
+	n := header + 8*int(bins)
+	if len(b) < n {
+		return ErrPayloadIncomplete
+	}
+	if len(b) > n {
+		return ErrPayloadTooLong
+	}
+	return nil
 }
 
-var (
-	errParseBaseBandIQNotEnoughBytes   = errors.New("baseband data does contain enough bytes")
-	errParseBaseBandIQIncompletePacket = errors.New("baseband data does contain a full packet of data")
-)
+// float32s decodes n little endian float32 from b, nil when n is 0.
+func float32s(b []byte, n uint32) []float64 {
+	if n == 0 {
+		return nil
+	}
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return v
+}
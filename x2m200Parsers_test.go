@@ -1,6 +1,7 @@
 package xethru
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -11,19 +12,23 @@ func TestParse(t *testing.T) {
 		err  error
 		resp interface{}
 	}{
-		// {[]byte{0xFF}, errParseNotImplemented, nil},
-		{[]byte{}, errNoData, nil},
-		{[]byte{appDataByte, respirationStartByte}, errParseRespDataNotEnoughBytes, Respiration{}},
-		{[]byte{appDataByte, sleepStartByte}, errParseSleepDataNotEnoughBytes, Sleep{}},
-		{[]byte{appDataByte, basebandPhaseAmpltudeStartByte}, errParseBaseBandAPNotEnoughBytes, BaseBandAmpPhase{}},
-		{[]byte{appDataByte, basebandIQStartByte}, errParseBaseBandIQNotEnoughBytes, BaseBandIQ{}},
-		// {[]byte{appDataByte, 0x00}, errParseNotImplemented, nil},
-		// {[]byte{appDataByte, sleepStartByte}, errParseSleepDataNotEnoughBytes, BaseBandIQ{}},
+		// {[]byte{0xFF}, ErrParseNotImplemented, nil},
+		{[]byte{}, ErrNoData, nil},
+		{[]byte{appDataByte, respirationStartByte}, ErrPayloadTooShort, Respiration{}},
+		{[]byte{appDataByte, sleepStartByte}, ErrPayloadTooShort, Sleep{}},
+		{[]byte{appDataByte, basebandPhaseAmpltudeStartByte}, ErrPayloadTooShort, BaseBandAmpPhase{}},
+		{[]byte{appDataByte, basebandIQStartByte}, ErrPayloadTooShort, BaseBandIQ{}},
+		{[]byte{appDataByte}, ErrPayloadTooShort, nil},
+		{[]byte{systemMesg}, ErrPayloadTooShort, nil},
+		{append(respirationPayload(), 0x00), ErrPayloadTooLong, Respiration{}},
+		{basebandIQPayload(maxBins + 1)[:iqheadersize], ErrTooManyBins, BaseBandIQ{}},
+		// {[]byte{appDataByte, 0x00}, ErrParseNotImplemented, nil},
+		// {[]byte{appDataByte, sleepStartByte}, ErrPayloadTooShort, BaseBandIQ{}},
 	}
 	for n, c := range cases {
 		resp, err := parse(c.b)
 		// log.Printf("%#v, %#v \n", resp, err)
-		if err != c.err {
+		if !errors.Is(err, c.err) {
 			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
 		}
 		resptype := reflect.TypeOf(resp)
@@ -44,7 +49,7 @@ func TestParseRespiration(t *testing.T) {
 	}{
 		{
 			[]byte{appDataByte, respirationStartByte},
-			errParseRespDataNotEnoughBytes,
+			ErrPayloadTooShort,
 			Respiration{
 				Time:          0,
 				Status:        0,
@@ -89,7 +94,7 @@ func TestParseSleep(t *testing.T) {
 	}{
 		{
 			[]byte{appDataByte, respirationStartByte},
-			errParseSleepDataNotEnoughBytes,
+			ErrPayloadTooShort,
 			Sleep{
 				Time:          0,
 				Status:        0,
@@ -137,16 +142,26 @@ func TestBasebandAmpPhase(t *testing.T) {
 	}{
 		{
 			[]byte{appDataByte, basebandPhaseAmpltudeStartByte},
-			errParseBaseBandAPNotEnoughBytes,
+			ErrPayloadTooShort,
 			BaseBandAmpPhase{}}, {
 			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 			nil,
 			BaseBandAmpPhase{}}, {
 			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-			errParseBaseBandAPIncompletePacket,
+			ErrPayloadIncomplete,
 			BaseBandAmpPhase{}}, {
 			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 			nil,
+			BaseBandAmpPhase{}}, {
+			// 4 bins need 32 bytes of samples, not 4
+			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			ErrPayloadIncomplete,
+			BaseBandAmpPhase{}}, {
+			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			ErrTooManyBins,
+			BaseBandAmpPhase{}}, {
+			[]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			ErrPayloadTooLong,
 			BaseBandAmpPhase{}},
 	}
 	for n, c := range cases {
@@ -168,25 +183,68 @@ func TestBasebandIQ(t *testing.T) {
 	}{
 		{
 			[]byte{appDataByte, basebandPhaseAmpltudeStartByte},
-			errParseBaseBandIQNotEnoughBytes,
+			ErrPayloadTooShort,
 			BaseBandIQ{}}, {
 			[]byte{appDataByte, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 			nil,
 			BaseBandIQ{}}, {
 			[]byte{appDataByte, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-			errParseBaseBandIQIncompletePacket,
+			ErrPayloadIncomplete,
 			BaseBandIQ{}}, {
 			[]byte{appDataByte, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 			nil,
+			BaseBandIQ{}}, {
+			basebandIQPayload(138)[:iqheadersize+8*100],
+			ErrPayloadIncomplete,
+			BaseBandIQ{}}, {
+			basebandIQPayload(maxBins + 1),
+			ErrTooManyBins,
+			BaseBandIQ{}}, {
+			append(basebandIQPayload(2), 0x00),
+			ErrPayloadTooLong,
 			BaseBandIQ{}},
 	}
 	for n, c := range cases {
 		// log.Println(len(c.b))
-		_, err := parseBaseBandIQ(c.b)
+		resp, err := parseBaseBandIQ(c.b)
 		// log.Printf("%#v, %#v \n", resp, err)
 		if err != c.err {
 			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
 		}
-		// TODO: Validate response
+		if err == nil && (len(resp.SigI) != int(resp.Bins) || len(resp.SigQ) != int(resp.Bins)) {
+			t.Errorf("test %d Expected: %d bins, got %d %d\n", n, resp.Bins, len(resp.SigI), len(resp.SigQ))
+		}
 	}
 }
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{appDataByte})
+	f.Add([]byte{ack})
+	f.Add([]byte{systemMesg, systemReady})
+	f.Add(respirationPayload())
+	f.Add(sleepPayload())
+	f.Add(basebandIQPayload(0))
+	f.Add(basebandIQPayload(3))
+	f.Add([]byte{appDataByte, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, err := parse(b)
+		if err != nil {
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Expected: *ParseError, got %T\n", err)
+			}
+			return
+		}
+		switch m := v.(type) {
+		case BaseBandIQ:
+			if len(m.SigI) != int(m.Bins) || len(m.SigQ) != int(m.Bins) {
+				t.Fatalf("Expected: %d bins, got %d %d\n", m.Bins, len(m.SigI), len(m.SigQ))
+			}
+		case BaseBandAmpPhase:
+			if len(m.Amplitude) != int(m.Bins) || len(m.Phase) != int(m.Bins) {
+				t.Fatalf("Expected: %d bins, got %d %d\n", m.Bins, len(m.Amplitude), len(m.Phase))
+			}
+		}
+	})
+}
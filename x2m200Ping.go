@@ -18,9 +18,8 @@
 package xethru
 
 import (
+	"context"
 	"encoding/binary"
-	"errors"
-	"log"
 	"time"
 )
 
@@ -34,71 +33,69 @@ const (
 // Ping send the xethru ping command and will wait for the timeout to expire
 // before closing and returning an error, It is Recommended that you Reset or
 // panic if a Ping fails
-func (x x2m200Frame) Ping(t time.Duration) (bool, error) {
-	resp := make(chan []byte)
-	x.ping(resp)
+func (x *x2m200Frame) Ping(t time.Duration) (bool, error) {
 	if t == 0 {
 		t = time.Millisecond * 100
 	}
-	select {
-	case <-time.After(t):
-
-	case r := <-resp:
-		ok, err := isValidPingResponse(r)
-		return ok, err
-	}
-
-	return false, errPingTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), t)
+	defer cancel()
+	return x.PingContext(ctx)
+}
 
+// PingContext sends the ping command and waits for the response until ctx
+// is done. Frames that are not a ping response are skipped.
+func (x *x2m200Frame) PingContext(ctx context.Context) (bool, error) {
+	return ping(ctx, x)
 }
 
-var errPingTimeout = errors.New("ping timeout")
+// PingContext pings the module while Run may be streaming.
+func (r *Module) PingContext(ctx context.Context) (bool, error) {
+	r.d.cmd.Lock()
+	defer r.d.cmd.Unlock()
+	ok, err := transact(ctx, r, pingCommand())
+	return ok, pingError(err)
+}
 
-func (x x2m200Frame) ping(response chan []byte) {
-	go func() {
-		// build ping command
-		// find betterway to do this
-		seed := make([]byte, 4)
-		binary.BigEndian.PutUint32(seed, x2m200PingSeed)
-		// fmt.Printf("seed %x\n", seed)
-		cmd := []byte{x2m200PingCommand, seed[0], seed[1], seed[2], seed[3]}
-		// Write to Framer
-		n, err := x.Write(cmd)
-		// x.w.Flush()
-		if err != nil {
-			log.Printf("Ping Write Error %v, number of bytes %d\n", err, n)
-		}
+// ping works on any Framer, like reset.
+func ping(ctx context.Context, x Framer) (bool, error) {
+	ok, err := Transact(ctx, x, pingCommand())
+	return ok, pingError(err)
+}
 
-		// Read from Framer
-		b := make([]byte, 20)
-		n, err = x.Read(b)
-		if err != nil {
-			log.Printf("Ping Read Error %v, number of bytes %d\n", err, n)
-		}
-		// retry
-		for n == 0 {
-			n, err = x.Read(b)
-			if err != nil {
-				log.Printf("Ping Read Error %v, number of bytes %d\n", err, n)
-				log.Printf("bytes %x\n", b)
+// pingCommand skips the frames other than the ping response, it is left
+// to ctx to time out.
+func pingCommand() Command[bool] {
+	seed := make([]byte, 4)
+	binary.BigEndian.PutUint32(seed, x2m200PingSeed)
+	return Command[bool]{
+		Name:    "ping",
+		Payload: []byte{x2m200PingCommand, seed[0], seed[1], seed[2], seed[3]},
+		Reply: func(p []byte) (bool, bool, error) {
+			if len(p) == 0 || p[0] != x2m200PingCommand {
+				return false, false, nil
 			}
-		}
-		// send response []byte back to caller
-		response <- b[:n]
-
-	}()
+			ok, err := isValidPingResponse(p)
+			return ok, true, err
+		},
+	}
+}
 
+func pingError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrPingTimeout
+	}
+	return err
 }
 
 //
 func isValidPingResponse(b []byte) (bool, error) {
-	// check response length is
-	// if len(b) != 5 {
-	// 	return false, errPingNotEnoughBytes
-	// }
+	// check response length is <PingCommand> + 4 bytes
+	if len(b) != 5 {
+		return false, ErrBadPingResponse
+	}
 	// Check response starts with Ping Byte
 	if b[0] != x2m200PingCommand {
-		return false, errPingDoesNotStartWithPingCMD
+		return false, ErrBadPingResponse
 	}
 	// check for valid response first striping off startByte
 	resp := binary.BigEndian.Uint32(b[1:])
@@ -108,11 +105,6 @@ func isValidPingResponse(b []byte) (bool, error) {
 	case x2m200PingResponseReady:
 		return true, nil
 	default:
-		return false, errPingDoesNotContainResponse
+		return false, ErrBadPingResponse
 	}
 }
-
-//
-var errPingDoesNotContainResponse = errors.New("ping response does not contain a valid ping response")
-var errPingNotEnoughBytes = errors.New("ping response does not contain correct number of bytes")
-var errPingDoesNotStartWithPingCMD = errors.New("ping response does not start with ping response start byte")
@@ -0,0 +1,47 @@
+package xethru
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func pingResponse(r uint32) []byte {
+	b := []byte{x2m200PingCommand, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], r)
+	return b
+}
+
+func TestIsValidPingResponse(t *testing.T) {
+	cases := []struct {
+		b   []byte
+		ok  bool
+		err error
+	}{
+		{pingResponse(x2m200PingResponseReady), true, nil},
+		{pingResponse(x2m200PingResponseNotReady), false, nil},
+		{pingResponse(0x01020304), false, ErrBadPingResponse},
+		{[]byte{}, false, ErrBadPingResponse},
+		{[]byte{x2m200PingCommand}, false, ErrBadPingResponse},
+		{append(pingResponse(x2m200PingResponseReady), 0x00), false, ErrBadPingResponse},
+		{[]byte{ack, 0xaa, 0xee, 0xae, 0xea}, false, ErrBadPingResponse},
+	}
+	for n, c := range cases {
+		ok, err := isValidPingResponse(c.b)
+		if ok != c.ok || err != c.err {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, c.ok, c.err, ok, err)
+		}
+	}
+}
+
+func FuzzIsValidPingResponse(f *testing.F) {
+	f.Add(pingResponse(x2m200PingResponseReady))
+	f.Add(pingResponse(x2m200PingResponseNotReady))
+	f.Add([]byte{})
+	f.Add([]byte{x2m200PingCommand})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ok, err := isValidPingResponse(b)
+		if ok && err != nil {
+			t.Fatalf("Expected: no error when ready, got %v\n", err)
+		}
+	})
+}
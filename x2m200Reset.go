@@ -20,9 +20,10 @@
 package xethru
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 )
 
 const (
@@ -30,81 +31,54 @@ const (
 )
 
 // Reset should be the first to be called when connecting to the X2M200 sensor
-func (x x2m200Frame) Reset() (bool, error) {
-	last := "disableBaseBand"
-
-disableBaseBand:
-	// log.Println("disableBaseBand")
-	// Disbale Basebands
-	//  ([]byte{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
-	n, err := x.Write([]byte{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	last = "disableBaseBand"
-	goto reRead
-
-disableRespiration:
-	// log.Println("Disable Respiration")
-	// Disbale Basebands
-	//    				   {0x90, 0x71, 0x11, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	// n, err = x.Write([]byte{0x90, 0x71, 0x11, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	n, err = x.Write([]byte{0x20, 0x11})
-	last = "disableRespiration"
-	goto reRead
+func (x *x2m200Frame) Reset() (bool, error) {
+	return x.ResetContext(context.Background())
+}
 
-reset:
-	// log.Println("Reset")
-	n, err = x.Write([]byte{resetCmd})
-	if err != nil {
-		// log.Printf("Reset Write Error %v, number of bytes %d\n", err, n)
-		return false, err
-	}
-	last = "reset"
-	goto reRead
+// ResetContext is Reset that gives up when ctx is done.
+func (x *x2m200Frame) ResetContext(ctx context.Context) (bool, error) {
+	return reset(ctx, x)
+}
 
-reRead:
-	b := make([]byte, 2048)
-	n, err = x.Read(b)
-	// log.Println("Reading")
-	if err != nil {
-		// log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		if err == io.EOF {
-			return true, nil
+// reset stops any output and resets the module, it only uses the Framer
+// interface so that wrapping Framers see all of the traffic. A running
+// respiration or sleep app is stopped first, so that its messages do not
+// get in the way of the acks, a module with no app running answers the
+// stop as not recognised.
+func reset(ctx context.Context, x Framer) (bool, error) {
+	command := func(name string, p []byte) Command[struct{}] {
+		return Command[struct{}]{
+			Name:    name,
+			Payload: p,
+			Reply:   expectAck,
+			Timeout: DefaultCommandTimeout,
+			Retries: DefaultCommandRetries,
 		}
 	}
-	if n == 0 {
-		goto reset
+	run := func(c Command[struct{}]) error {
+		_, err := Transact(ctx, x, c)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reset: %s: %w", c.Name, err)
+		}
+		return err
 	}
-	state, err := parse(b[:n])
-	if err != nil {
-		// log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return false, err
+	// a replay has nothing left to answer with, io.EOF ends it early
+	var err error
+	for _, p := range driverOf(x).disable {
+		if err = run(command("disable output", p)); err != nil {
+			break
+		}
 	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			if last == "reset" {
-				// log.Println("Yay we got there")
-				return true, nil
-			}
-			goto reset
+	if err == nil {
+		if err = run(command("stop app", []byte{0x20, 0x11})); errors.Is(err, ErrCommandNotRecognised) {
+			err = nil
 		}
-
-		// return x.Reset()
-	case BaseBandAmpPhase:
-		goto disableBaseBand
-	case Respiration:
-		goto disableRespiration
-	// case Sleep:
-	// 	// TODO DISABLE Sleep
-	default:
-		log.Printf("\n\n%#+v\n\n", state)
-		goto reRead
-
 	}
-
-	return false, nil
+	if err == nil {
+		err = run(command("reset", []byte{resetCmd}))
+	}
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return true, nil
 }
-
-var errResetNotEnoughBytes = errors.New("reset not enough bytes in response")
-var errResetResponseError = errors.New("reset did not contain a correct response")
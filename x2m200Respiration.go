@@ -1,11 +1,12 @@
 package xethru
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	"time"
 )
 
 type status uint32
@@ -34,33 +35,44 @@ const (
 	someotherState respirationState = 7
 )
 
-// NewModule creates
+// NewModule creates a Module running the app of mode, respiration or sleep
+// for instance, on the device given to Open. Load fails with
+// ErrUnknownMode when that device has no app for mode.
 func NewModule(f Framer, mode string) *Module {
-	var appID [4]byte
-	// parser := parse
-	switch mode {
-	case "respiration":
-		appID = [4]byte{0xd6, 0xa2, 0x23, 0x14}
-		// parser = parse
-	case "sleep":
-		log.Println("Loading Sleep Module")
-		appID = [4]byte{0x17, 0x7b, 0xf1, 0x00}
-		// parser = parse
-	case "basebandiq":
-		appID = [4]byte{0x14, 0x23, 0xa2, 0xd6}
-	case "basebandampphase":
-		appID = [4]byte{0x14, 0x23, 0xa2, 0xd6}
+	// the app IDs and the parser come with the device given to Open
+	drv := driverOf(f)
+	app, ok := drv.apps[mode]
+	var modeErr error
+	if !ok {
+		modeErr = fmt.Errorf("%w: %q on %s", ErrUnknownMode, mode, drv.name)
 	}
 	module := &Module{
 		f:       f,
-		AppID:   appID,
-		Timeout: 500 * time.Millisecond,
+		d:       NewDemux(f),
+		drv:     drv,
+		modeErr: modeErr,
+		AppID:   app,
+		Timeout: DefaultCommandTimeout,
+		Retries: DefaultCommandRetries,
 		Data:    make(chan interface{}),
 	}
 
 	return module
 }
 
+// Dropped returns how many data frames Run lost because stream was not
+// read fast enough, they are dropped rather than holding up the replies
+// to commands.
+func (r *Module) Dropped() uint64 {
+	return r.d.Dropped()
+}
+
+// Close stops the Module reading its Framer, which is left open for
+// another Module or Reset. The Module is not to be used after Close.
+func (r *Module) Close() error {
+	return r.d.Close()
+}
+
 // Reset is
 // func (r *Module) Reset() (bool, error) {
 // 	log.Println("Called Reset")
@@ -86,52 +98,18 @@ const x2m200SetLEDControl = 0x24
 // Example: <Start> + <XTS_SPC_MOD_SETLEDCONTROL> + <Mode> + <Reserved> + <CRC> + <End>
 // Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
 func (r *Module) SetLEDMode() error {
+	return r.SetLEDModeContext(context.Background())
+}
+
+// SetLEDModeContext is SetLEDMode that gives up when ctx is done.
+func (r *Module) SetLEDModeContext(ctx context.Context) error {
 	// if r.LEDMode == nil {
 	// 	r.LEDMode == LEDOff
 	// }
-	log.Println("Setting LED MODE")
-	n, err := r.f.Write([]byte{x2m200SetLEDControl, byte(r.LEDMode), 0x00})
-	if err != nil {
-		return err
-	}
-
-	attempts := 0
-reRead:
-	b := make([]byte, 2048)
-	n, err = r.f.Read(b)
-	if err != nil {
-		log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		return err
-	}
-	state, err := parse(b[:n])
-	if err != nil {
-		log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return err
+	if err := r.command(ctx, "set led mode", []byte{x2m200SetLEDControl, byte(r.LEDMode), 0x00}, expectAck); err != nil {
+		return fmt.Errorf("set led mode: %w", err)
 	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			return nil
-		}
-	default:
-		if attempts < 20 {
-			attempts++
-			goto reRead
-		}
-
-	}
-	return fmt.Errorf("failed to set led mode\n")
-
-	// b := make([]byte, 1024)
-	// n, err = r.f.Read(b)
-	// if err != nil {
-	// 	log.Println(err, n)
-	// }
-	// if b[0] != x2m200Ack {
-	// 	log.Println("Not Ack")
-	// }
+	return nil
 }
 
 const (
@@ -139,16 +117,17 @@ const (
 	x2m200Set        = 0x10
 )
 
-var x2m200DetectionZone = [4]byte{0x96, 0xa1, 0x0a, 0x1c}
-
-// var x2m200DetectionZone = [4]byte{0x1c, 0x0a, 0xa1, 0x96}
+var x2m200DetectionZone = [4]byte{0x1c, 0x0a, 0xa1, 0x96}
 
 // SetDetectionZone is
 // Example: <Start> + <XTS_SPC_APPCOMMAND> + <XTS_SPCA_SET> + [XTS_ID_DETECTION_ZONE(i)] + [Start(f)] + [End(f)] + <CRC> + <End>
 // Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
-func (r Module) SetDetectionZone(start, end float64) error {
-	log.Printf("Setting Detection zone starting at %2.2fm ending at %2.2fm\n", start, end)
+func (r *Module) SetDetectionZone(start, end float64) error {
+	return r.SetDetectionZoneContext(context.Background(), start, end)
+}
 
+// SetDetectionZoneContext is SetDetectionZone that gives up when ctx is done.
+func (r *Module) SetDetectionZoneContext(ctx context.Context, start, end float64) error {
 	r.DetectionZoneStart = float32(start)
 	r.DetectionZoneEnd = float32(end)
 
@@ -158,42 +137,11 @@ func (r Module) SetDetectionZone(start, end float64) error {
 	binary.LittleEndian.PutUint32(startbytes, math.Float32bits(r.DetectionZoneStart))
 	binary.LittleEndian.PutUint32(endbytes, math.Float32bits(r.DetectionZoneEnd))
 
-	// n, err := r.f.Write([]byte{x2m200AppCommand, x2m200Set, x2m200DetectionZone[0], x2m200DetectionZone[1], x2m200DetectionZone[2], x2m200DetectionZone[3], startbytes[0], startbytes[1], startbytes[2], startbytes[3], endbytes[0], endbytes[1], endbytes[2], endbytes[3]})
-
-	n, err := r.f.Write([]byte{0x10, 0x10, 0x1c, 0x0a, 0xa1, 0x96, startbytes[0], startbytes[1], startbytes[2], startbytes[3], endbytes[0], endbytes[1], endbytes[2], endbytes[3]})
-	if err != nil {
-		return err
-		// log.Println(err, n)
-	}
-
-	attempts := 0
-reRead:
-	b := make([]byte, 2048)
-	n, err = r.f.Read(b)
-	if err != nil {
-		log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		return err
-	}
-	state, err := parse(b[:n])
+	err := r.command(ctx, "set detection zone", []byte{x2m200AppCommand, x2m200Set, x2m200DetectionZone[0], x2m200DetectionZone[1], x2m200DetectionZone[2], x2m200DetectionZone[3], startbytes[0], startbytes[1], startbytes[2], startbytes[3], endbytes[0], endbytes[1], endbytes[2], endbytes[3]}, expectAck)
 	if err != nil {
-		log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return err
-	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			return nil
-		}
-	default:
-		if attempts < 20 {
-			attempts++
-			goto reRead
-		}
-
+		return fmt.Errorf("set detection zone %2.2f %2.2f: %w", start, end, err)
 	}
-	return fmt.Errorf("failed to set detection zone %2.2f %2.2f\n", start, end)
+	return nil
 }
 
 // b := make([]byte, 1024)
@@ -213,8 +161,12 @@ var x2m200Sensitivity = [4]byte{0x2b, 0x11, 0xa5, 0x10}
 // SetSensitivity is
 // Example: <Start> + <XTS_SPC_APPCOMMAND> + <XTS_SPCA_SET> + [XTS_ID_SENSITIVITY(i)] + [Sensitivity(i)]+ <CRC> + <End>
 // Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
-func (r Module) SetSensitivity(sensitivity int) error {
+func (r *Module) SetSensitivity(sensitivity int) error {
+	return r.SetSensitivityContext(context.Background(), sensitivity)
+}
 
+// SetSensitivityContext is SetSensitivity that gives up when ctx is done.
+func (r *Module) SetSensitivityContext(ctx context.Context, sensitivity int) error {
 	if sensitivity > 9 {
 		sensitivity = 9
 	}
@@ -226,38 +178,11 @@ func (r Module) SetSensitivity(sensitivity int) error {
 	sensitivitybytes := make([]byte, 4)
 	binary.LittleEndian.PutUint32(sensitivitybytes, r.Sensitivity)
 
-	n, err := r.f.Write([]byte{x2m200AppCommand, x2m200Set, x2m200Sensitivity[0], x2m200Sensitivity[1], x2m200Sensitivity[2], x2m200Sensitivity[3], sensitivitybytes[0], sensitivitybytes[1], sensitivitybytes[2], sensitivitybytes[3]})
+	err := r.command(ctx, "set sensitivity", []byte{x2m200AppCommand, x2m200Set, x2m200Sensitivity[0], x2m200Sensitivity[1], x2m200Sensitivity[2], x2m200Sensitivity[3], sensitivitybytes[0], sensitivitybytes[1], sensitivitybytes[2], sensitivitybytes[3]}, expectAck)
 	if err != nil {
-		log.Println(err, n)
+		return fmt.Errorf("set sensitivity %d: %w", sensitivity, err)
 	}
-	attempts := 0
-reRead:
-	b := make([]byte, 2048)
-	n, err = r.f.Read(b)
-	if err != nil {
-		log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		return err
-	}
-	state, err := parse(b[:n])
-	if err != nil {
-		log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return err
-	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			return nil
-		}
-	default:
-		if attempts < 20 {
-			attempts++
-			goto reRead
-		}
-
-	}
-	return fmt.Errorf("failed to set sensitivity %d\n", sensitivity)
+	return nil
 }
 
 const (
@@ -268,113 +193,44 @@ const (
 // Load is
 // Example: <Start> + <XTS_SPC_MOD_LOADAPP> + [AppID(i)] + <CRC> + <End>
 // Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
-func (r Module) Load() error {
-load:
-	n, err := r.f.Write([]byte{x2m200LoadModule, r.AppID[0], r.AppID[1], r.AppID[2], r.AppID[3]})
-	if err != nil {
-		log.Println(err, n)
-		return err
-	}
-	attempts := 0
-reRead:
-	b := make([]byte, 2048)
-	n, err = r.f.Read(b)
-	if err != nil {
-		log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		return err
+func (r *Module) Load() error {
+	return r.LoadContext(context.Background())
+}
+
+// LoadContext is Load that gives up when ctx is done.
+func (r *Module) LoadContext(ctx context.Context) error {
+	if r.modeErr != nil {
+		return fmt.Errorf("load app: %w", r.modeErr)
 	}
-	state, err := parse(b[:n])
+	err := r.command(ctx, "load app", []byte{x2m200LoadModule, r.AppID[0], r.AppID[1], r.AppID[2], r.AppID[3]}, expectLoaded)
 	if err != nil {
-		log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return err
+		return fmt.Errorf("load app %#x: %w", r.AppID, err)
 	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			return nil
-		}
-		if s.Message == "System Still booting" {
-			if attempts < 20 {
-				goto reRead
-			}
-		}
-		if s.Message == "System Ready" {
-			if attempts < 20 {
-				goto load
-			}
-		}
-		// return x.Reset()
-	default:
-		if attempts < 20 {
-			goto reRead
-		}
+	return nil
+}
 
+// expectLoaded waits out a booting module, and sends the load again once
+// it reports ready as the first one went to the boot loader.
+func expectLoaded(p []byte) (struct{}, bool, error) {
+	if len(p) > 1 && p[0] == systemMesg && p[1] == systemReady {
+		return struct{}{}, false, ErrResend
 	}
-
-	return fmt.Errorf("did not recive ack for load module\n")
+	return expectAck(p)
 }
 
 // <Start> + <XTS_SPC_DIR_COMMAND> + <XTS_SDC_APP_SETINT> + [XTS_SACR_OUTPUTBASEBAND(i)] + [Length(i)] + [EnableCode(i)] + <CRC> + <End> Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
-func (r Module) Enable(mode string) error {
-	switch mode {
-	case "phase":
-		log.Println("Enable Phase Amp Baseband")
-		n, err := r.f.Write([]byte{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
-
-		if err != nil {
-			log.Println(err, n)
-			return err
-		}
-	case "iq":
-		log.Println("Enable IQ Baseband")
-
-		n, err := r.f.Write([]byte{0x90, 0x71, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00})
-
-		if err != nil {
-			log.Println(err, n)
-			return err
-		}
-	default:
-		log.Println("Disable Baseband")
-
-		n, err := r.f.Write([]byte{0x90, 0x71, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+func (r *Module) Enable(mode string) error {
+	return r.EnableContext(context.Background(), mode)
+}
 
-		if err != nil {
-			log.Println(err, n)
-			return err
+// EnableContext is Enable that gives up when ctx is done.
+func (r *Module) EnableContext(ctx context.Context, mode string) error {
+	for _, p := range r.drv.baseband(mode) {
+		if err := r.command(ctx, "enable "+mode, p, expectAck); err != nil {
+			return fmt.Errorf("enable %s: %w", mode, err)
 		}
 	}
-
-	attempts := 0
-reRead:
-	b := make([]byte, 2048)
-	n, err := r.f.Read(b)
-	if err != nil {
-		log.Printf("Reset read Error %v, number of bytes %d\n", err, n)
-		return err
-	}
-	state, err := parse(b[:n])
-	if err != nil {
-		log.Printf("Parse read Error %v, state %#+v \n", err, state)
-		return err
-	}
-	// log.Printf("Debug state %#+v \n", state)
-	switch state.(type) {
-	case SystemMessage:
-		s := state.(SystemMessage)
-		if s.Message == "Command Ack'ed" {
-			return nil
-		}
-	default:
-		if attempts < 20 {
-			attempts++
-			goto reRead
-		}
-
-	}
-	return fmt.Errorf("failed to set Enable %s mode", mode)
+	return nil
 }
 
 // 	b := make([]byte, 1024)
@@ -392,35 +248,90 @@ reRead:
 // }
 
 // Run start app
-func (r Module) Run(stream chan interface{}) {
-	defer r.f.Write([]byte{0x20, 0x11})
+func (r *Module) Run(stream chan interface{}) {
+	r.RunContext(context.Background(), stream)
+}
 
-	n, err := r.f.Write([]byte{0x20, 0x01})
-	if err != nil {
-		log.Println(err, n)
-	}
+// RunContext starts the app and streams parsed data until ctx is done or
+// the Framer fails, io.EOF at the end of a replay for instance. Data that
+// comes in while up to 1000 messages wait for stream is dropped and
+// counted in Dropped.
+func (r *Module) RunContext(ctx context.Context, stream chan interface{}) error {
+	// commands can still be sent while streaming, their replies do not
+	// go through here
+	sub := r.d.Subscribe(1000)
+	defer sub.Close()
 
-	output := make(chan []byte, 1000)
+	defer r.f.Write([]byte{0x20, 0x11})
 
-	go func(out chan []byte) {
-		for {
-			b := make([]byte, 2048)
-			n, err := r.f.Read(b)
-			if err != nil {
-				log.Println(err)
-			}
-			out <- b[:n]
-		}
-	}(output)
+	if _, err := r.f.WriteFrameContext(ctx, []byte{0x20, 0x01}); err != nil {
+		return fmt.Errorf("start app: %w", err)
+	}
 
 	for {
 		select {
-		case out := <-output:
-			data, err := parse(out)
+		case out, ok := <-sub.C:
+			if !ok {
+				if err := r.d.Err(); err != nil {
+					return err
+				}
+				return ctx.Err()
+			}
+			data, err := r.drv.parse(out.Payload)
 			if err != nil {
 				log.Println(err)
 			}
-			stream <- data
+			// parsers copy what they need so the frame can go back now
+			out.Release()
+			select {
+			case stream <- data:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
+
+// command sends p with the Module's timeout and retry policy, one command
+// at a time so replies do not get mixed up.
+func (r *Module) command(ctx context.Context, name string, p []byte, reply func([]byte) (struct{}, bool, error)) error {
+	_, err := query(ctx, r, name, p, reply)
+	return err
+}
+
+// query is command for the commands that answer with a value.
+func query[T any](ctx context.Context, r *Module, name string, p []byte, reply func([]byte) (T, bool, error)) (T, error) {
+	r.d.cmd.Lock()
+	defer r.d.cmd.Unlock()
+	v, err := transact(ctx, r, Command[T]{
+		Name:    name,
+		Payload: p,
+		Reply:   reply,
+		Timeout: r.Timeout,
+		Retries: r.Retries,
+	})
+	if errors.Is(err, ErrCommandTimeout) || errors.Is(err, ErrResend) {
+		err = fmt.Errorf("%w: %w", ErrNoAck, err)
+	}
+	return v, err
+}
+
+// writeCommand drops stale replies before sending, so that a command does
+// not take the answer to an earlier one.
+func (r *Module) writeCommand(ctx context.Context, p []byte) error {
+	r.d.drain()
+	_, err := r.f.WriteFrameContext(ctx, p)
+	return err
+}
+
+// nextReply returns the next frame that is not app data, data frames keep
+// going to Run.
+func (r *Module) nextReply(ctx context.Context) (*Frame, error) {
+	return r.d.readReply(ctx)
+}
+
+func (r *Module) commandEvents() func(CommandEvent) {
+	return commandEvents(r.f)
+}
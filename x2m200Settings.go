@@ -0,0 +1,128 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	x2m200Get           = 0x11
+	x2m200Reply         = 0x14
+	x2m200GetLEDControl = 0x25
+)
+
+// GetLEDMode asks the module which LED mode it is using.
+// Example: <Start> + <XTS_SPC_MOD_GETLEDCONTROL> + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_REPLY> + <XTS_SPC_MOD_GETLEDCONTROL> + <Mode> + <CRC> + <End>
+func (r *Module) GetLEDMode() (ledMode, error) {
+	return r.GetLEDModeContext(context.Background())
+}
+
+// GetLEDModeContext is GetLEDMode that gives up when ctx is done.
+func (r *Module) GetLEDModeContext(ctx context.Context) (ledMode, error) {
+	v, err := query(ctx, r, "get led mode", []byte{x2m200GetLEDControl}, expectReply([]byte{x2m200GetLEDControl}, 1))
+	if err != nil {
+		return 0, fmt.Errorf("get led mode: %w", err)
+	}
+	return ledMode(v[0]), nil
+}
+
+// GetDetectionZone asks the module where its detection zone starts and
+// ends, in meters.
+// Example: <Start> + <XTS_SPC_APPCOMMAND> + <XTS_SPCA_GET> + [XTS_ID_DETECTION_ZONE(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_REPLY> + <XTS_SPCA_GET> + [XTS_ID_DETECTION_ZONE(i)] + [Start(f)] + [End(f)] + <CRC> + <End>
+func (r *Module) GetDetectionZone() (start, end float64, err error) {
+	return r.GetDetectionZoneContext(context.Background())
+}
+
+// GetDetectionZoneContext is GetDetectionZone that gives up when ctx is
+// done.
+func (r *Module) GetDetectionZoneContext(ctx context.Context) (start, end float64, err error) {
+	v, err := r.appGet(ctx, "get detection zone", x2m200DetectionZone, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("get detection zone: %w", err)
+	}
+	start = float64(math.Float32frombits(binary.LittleEndian.Uint32(v[0:4])))
+	end = float64(math.Float32frombits(binary.LittleEndian.Uint32(v[4:8])))
+	return start, end, nil
+}
+
+// GetSensitivity asks the module for its sensitivity, 0 to 9.
+// Example: <Start> + <XTS_SPC_APPCOMMAND> + <XTS_SPCA_GET> + [XTS_ID_SENSITIVITY(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_REPLY> + <XTS_SPCA_GET> + [XTS_ID_SENSITIVITY(i)] + [Sensitivity(i)] + <CRC> + <End>
+func (r *Module) GetSensitivity() (int, error) {
+	return r.GetSensitivityContext(context.Background())
+}
+
+// GetSensitivityContext is GetSensitivity that gives up when ctx is done.
+func (r *Module) GetSensitivityContext(ctx context.Context) (int, error) {
+	v, err := r.appGet(ctx, "get sensitivity", x2m200Sensitivity, 4)
+	if err != nil {
+		return 0, fmt.Errorf("get sensitivity: %w", err)
+	}
+	return int(binary.LittleEndian.Uint32(v)), nil
+}
+
+// appGet reads the n bytes of the app setting id.
+func (r *Module) appGet(ctx context.Context, name string, id [4]byte, n int) ([]byte, error) {
+	p := []byte{x2m200AppCommand, x2m200Get, id[0], id[1], id[2], id[3]}
+	return query(ctx, r, name, p, expectReply(p[1:], n))
+}
+
+// expectReply waits for the reply starting with prefix and returns a copy
+// of the n bytes that follow it, skipping anything else.
+func expectReply(prefix []byte, n int) func(p []byte) ([]byte, bool, error) {
+	return func(p []byte) ([]byte, bool, error) {
+		if len(p) < 1+len(prefix) || p[0] != x2m200Reply || string(p[1:1+len(prefix)]) != string(prefix) {
+			return nil, false, nil
+		}
+		v := p[1+len(prefix):]
+		switch {
+		case len(v) < n:
+			return nil, true, newParseError(p, ErrPayloadTooShort)
+		case len(v) > n:
+			return nil, true, newParseError(p, ErrPayloadTooLong)
+		}
+		return append([]byte(nil), v...), true, nil
+	}
+}
+
+// Verify reads the settings back from the module and compares them with
+// the ones last set on r, the detection zone is left out while
+// DetectionZoneEnd is 0. Every setting that differs is reported as an
+// ErrConfigMismatch.
+func (r *Module) Verify() error {
+	return r.VerifyContext(context.Background())
+}
+
+// VerifyContext is Verify that gives up when ctx is done.
+func (r *Module) VerifyContext(ctx context.Context) error {
+	var errs []error
+	mode, err := r.GetLEDModeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if mode != r.LEDMode {
+		errs = append(errs, fmt.Errorf("%w: led mode %v, module has %v", ErrConfigMismatch, r.LEDMode, mode))
+	}
+	if r.DetectionZoneEnd != 0 {
+		start, end, err := r.GetDetectionZoneContext(ctx)
+		if err != nil {
+			return err
+		}
+		if float32(start) != r.DetectionZoneStart || float32(end) != r.DetectionZoneEnd {
+			errs = append(errs, fmt.Errorf("%w: detection zone %2.2f %2.2f, module has %2.2f %2.2f", ErrConfigMismatch, r.DetectionZoneStart, r.DetectionZoneEnd, start, end))
+		}
+	}
+	sensitivity, err := r.GetSensitivityContext(ctx)
+	if err != nil {
+		return err
+	}
+	if uint32(sensitivity) != r.Sensitivity {
+		errs = append(errs, fmt.Errorf("%w: sensitivity %d, module has %d", ErrConfigMismatch, r.Sensitivity, sensitivity))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func zoneReply(start, end float32) []byte {
+	p := []byte{x2m200Reply, x2m200Get, 0x1c, 0x0a, 0xa1, 0x96, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(p[6:], math.Float32bits(start))
+	binary.LittleEndian.PutUint32(p[10:], math.Float32bits(end))
+	return p
+}
+
+func sensitivityReply(n byte) []byte {
+	return []byte{x2m200Reply, x2m200Get, 0x2b, 0x11, 0xa5, 0x10, n, 0, 0, 0}
+}
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		replies [][]byte
+		err     error
+	}{
+		{[][]byte{{x2m200Reply, x2m200GetLEDControl, 2}, zoneReply(0.5, 1.5), sensitivityReply(5)}, nil},
+		{[][]byte{{x2m200Reply, x2m200GetLEDControl, 2}, zoneReply(0.5, 1.5), sensitivityReply(9)}, ErrConfigMismatch},
+		{[][]byte{{x2m200Reply, x2m200GetLEDControl, 1}, zoneReply(0.4, 1.5), sensitivityReply(5)}, ErrConfigMismatch},
+		// a reply to something else is skipped until the attempt times out
+		{[][]byte{sensitivityReply(5), {x2m200Reply, x2m200GetLEDControl, 2}, zoneReply(0.5, 1.5), sensitivityReply(5)}, nil},
+		{[][]byte{{x2m200Reply, x2m200GetLEDControl}}, ErrPayloadTooShort},
+		{[][]byte{{errorByte, ProtocolErrorNotRecognised}}, ErrCommandNotRecognised},
+	}
+	for n, c := range cases {
+		m := (&fakeDevice{reply: func(i int, _ []byte) []byte {
+			if i >= len(c.replies) {
+				return nil
+			}
+			return c.replies[i]
+		}}).module(t, "respiration")
+		m.Timeout = 20 * time.Millisecond
+		m.LEDMode = LEDFull
+		m.DetectionZoneStart, m.DetectionZoneEnd = 0.5, 1.5
+		m.Sensitivity = 5
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := m.VerifyContext(ctx); !errors.Is(err, c.err) || (c.err == nil) != (err == nil) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		cancel()
+	}
+}
+
+// the setters keep what they sent for Verify
+func TestSettersUpdateModule(t *testing.T) {
+	m := new(fakeDevice).module(t, "respiration")
+	if err := m.SetDetectionZone(0.5, 1.5); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetSensitivity(12); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if m.DetectionZoneStart != 0.5 || m.DetectionZoneEnd != 1.5 || m.Sensitivity != 9 {
+		t.Errorf("Expected: %v %v %v, got %v %v %v\n", 0.5, 1.5, 9, m.DetectionZoneStart, m.DetectionZoneEnd, m.Sensitivity)
+	}
+}
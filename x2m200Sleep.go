@@ -0,0 +1,53 @@
+package xethru
+
+import (
+	"context"
+	"fmt"
+)
+
+// The app messages are turned on and off by the status word they start
+// with, the same way Enable does for baseband, see x2m200Output.
+const (
+	x2m200DirCommand = 0x90
+	x2m200AppSetInt  = 0x71
+)
+
+// SetSleepOutput turns the Sleep messages of the sleep app on or off, they
+// are on after Load. The sleep app is set up with SetDetectionZone and
+// SetSensitivity like the respiration app.
+func (r *Module) SetSleepOutput(on bool) error {
+	return r.SetSleepOutputContext(context.Background(), on)
+}
+
+// SetSleepOutputContext is SetSleepOutput that gives up when ctx is done.
+func (r *Module) SetSleepOutputContext(ctx context.Context, on bool) error {
+	return r.setOutput(ctx, "sleep", uint32(sleepApp), on)
+}
+
+// SetRespirationOutput turns the Respiration messages of the respiration
+// app on or off, they are on after Load.
+func (r *Module) SetRespirationOutput(on bool) error {
+	return r.SetRespirationOutputContext(context.Background(), on)
+}
+
+// SetRespirationOutputContext is SetRespirationOutput that gives up when
+// ctx is done.
+func (r *Module) SetRespirationOutputContext(ctx context.Context, on bool) error {
+	return r.setOutput(ctx, "respiration", uint32(respApp), on)
+}
+
+func (r *Module) setOutput(ctx context.Context, name string, id uint32, on bool) error {
+	state := "disable"
+	if on {
+		state = "enable"
+	}
+	name = state + " " + name + " output"
+	var code byte
+	if on {
+		code = 1
+	}
+	if err := r.command(ctx, name, r.drv.output(id, code), expectAck); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package xethru
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sleepCounter(counter uint32) []byte {
+	b := sleepPayload()
+	binary.LittleEndian.PutUint32(b[5:9], counter)
+	binary.LittleEndian.PutUint32(b[13:17], math.Float32bits(12))
+	return b
+}
+
+func TestResetStopsApp(t *testing.T) {
+	disable := []byte{0x90, 0x71, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	stop := []byte{0x20, 0x11}
+	cases := []struct {
+		first    []byte
+		stop     []byte
+		expected [][]byte
+		err      error
+	}{
+		{nil, nil, [][]byte{disable, stop, {resetCmd}}, nil},
+		{respirationPayload(), nil, [][]byte{disable, stop, {resetCmd}}, nil},
+		{sleepPayload(), nil, [][]byte{disable, stop, {resetCmd}}, nil},
+		// no app running
+		{nil, []byte{errorByte, ProtocolErrorNotRecognised}, [][]byte{disable, stop, {resetCmd}}, nil},
+		{nil, []byte{errorByte, ProtocolErrorInvalidAppID}, [][]byte{disable, stop}, ErrInvalidAppID},
+	}
+	for n, c := range cases {
+		d := &fakeDevice{first: c.first}
+		if c.stop != nil {
+			d.reply = func(_ int, p []byte) []byte {
+				if reflect.DeepEqual(p, stop) {
+					return c.stop
+				}
+				return []byte{ack}
+			}
+		}
+		x := d.open("x2m200")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if ok, err := x.(*x2m200Frame).ResetContext(ctx); ok != (c.err == nil) || !errors.Is(err, c.err) {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, c.err == nil, c.err, ok, err)
+		}
+		if cmds := d.commands(); !reflect.DeepEqual(cmds, c.expected) {
+			t.Errorf("test %d Expected: % x, got % x\n", n, c.expected, cmds)
+		}
+		cancel()
+		x.Close()
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	cases := []struct {
+		fn       func(m *Module) error
+		expected []byte
+	}{
+		{func(m *Module) error { return m.SetSleepOutput(false) }, []byte{0x90, 0x71, 0x6c, 0xa1, 0x75, 0x23, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{func(m *Module) error { return m.SetSleepOutput(true) }, []byte{0x90, 0x71, 0x6c, 0xa1, 0x75, 0x23, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+		{func(m *Module) error { return m.SetRespirationOutput(false) }, []byte{0x90, 0x71, 0x26, 0xfe, 0x75, 0x23, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for n, c := range cases {
+		d := new(fakeDevice)
+		if err := c.fn(d.module(t, "sleep")); err != nil {
+			t.Errorf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		if cmds := d.commands(); len(cmds) != 1 || !reflect.DeepEqual(cmds[0], c.expected) {
+			t.Errorf("test %d Expected: % x, got % x\n", n, c.expected, cmds)
+		}
+	}
+}
+
+func TestSleepApp(t *testing.T) {
+	d := &fakeDevice{frame: sleepCounter}
+	m := d.module(t, "sleep")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetDetectionZoneContext(ctx, 0.4, 2); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetSensitivityContext(ctx, 7); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetSleepOutputContext(ctx, true); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+
+	rctx, rcancel := context.WithCancel(ctx)
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- m.RunContext(rctx, stream) }()
+	for i := uint32(1); i <= 3; i++ {
+		v, ok := (<-stream).(Sleep)
+		if !ok || v.Counter != i || v.RPM != 12 {
+			t.Errorf("test %d Expected: Sleep counter %d rpm %v, got %+v\n", i, i, 12, v)
+		}
+	}
+	rcancel()
+	<-done
+	expected := [][]byte{
+		{x2m200LoadModule, 0x17, 0x7b, 0xf1, 0x00},
+		{x2m200AppCommand, x2m200Set, 0x1c, 0x0a, 0xa1, 0x96, 0xcd, 0xcc, 0xcc, 0x3e, 0x00, 0x00, 0x00, 0x40},
+		{x2m200AppCommand, x2m200Set, 0x2b, 0x11, 0xa5, 0x10, 0x07, 0x00, 0x00, 0x00},
+		{0x90, 0x71, 0x6c, 0xa1, 0x75, 0x23, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+		{0x20, 0x01},
+	}
+	if cmds := d.commands(); len(cmds) < len(expected) || !reflect.DeepEqual(cmds[:len(expected)], expected) {
+		t.Errorf("Expected: % x, got % x\n", expected, cmds)
+	}
+}
@@ -0,0 +1,113 @@
+package xethru
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// x2m200SystemInfo asks for one item of the system information.
+// Example: <Start> + <XTS_SPC_MOD_GETSYSTEMINFO> + <InfoCode> + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_REPLY> + <XTS_SPC_MOD_GETSYSTEMINFO> + <InfoCode> + [Info(s)] + <CRC> + <End>
+const x2m200SystemInfo = 0x30
+
+// System information codes.
+const (
+	systemInfoItemNumber   = 0x00
+	systemInfoOrderCode    = 0x01
+	systemInfoFirmwareID   = 0x02
+	systemInfoVersion      = 0x03
+	systemInfoBuild        = 0x04
+	systemInfoSerialNumber = 0x06
+)
+
+// SystemInfo is what a module says it is.
+type SystemInfo struct {
+	// Product is the order code, X2M200 for instance.
+	Product      string
+	ItemNumber   string
+	FirmwareID   string
+	Version      string
+	Build        string
+	SerialNumber string
+}
+
+func (s SystemInfo) String() string {
+	return fmt.Sprintf("%s %s firmware %s %s (%s) serial %s", s.Product, s.ItemNumber, s.FirmwareID, s.Version, s.Build, s.SerialNumber)
+}
+
+// systemInfoCommand asks for the item code, the string comes back without
+// its terminating NUL.
+func systemInfoCommand(code byte) Command[string] {
+	return Command[string]{
+		Name:    fmt.Sprintf("get system info %#02x", code),
+		Payload: []byte{x2m200SystemInfo, code},
+		Reply: func(p []byte) (string, bool, error) {
+			if len(p) < 3 || p[0] != x2m200Reply || p[1] != x2m200SystemInfo || p[2] != code {
+				return "", false, nil
+			}
+			return strings.TrimRight(string(p[3:]), "\x00"), true, nil
+		},
+	}
+}
+
+// readSystemInfo asks for every item in turn using get.
+func readSystemInfo(get func(c Command[string]) (string, error)) (SystemInfo, error) {
+	var s SystemInfo
+	for _, item := range []struct {
+		code byte
+		v    *string
+	}{
+		{systemInfoItemNumber, &s.ItemNumber},
+		{systemInfoOrderCode, &s.Product},
+		{systemInfoFirmwareID, &s.FirmwareID},
+		{systemInfoVersion, &s.Version},
+		{systemInfoBuild, &s.Build},
+		{systemInfoSerialNumber, &s.SerialNumber},
+	} {
+		v, err := get(systemInfoCommand(item.code))
+		if err != nil {
+			return SystemInfo{}, fmt.Errorf("system info: %w", err)
+		}
+		*item.v = v
+	}
+	return s, nil
+}
+
+// systemInfo works on any Framer, like ping. Each item is given timeout
+// and no retry.
+func systemInfo(ctx context.Context, f Framer, timeout time.Duration) (SystemInfo, error) {
+	return readSystemInfo(func(c Command[string]) (string, error) {
+		c.Timeout = timeout
+		return Transact(ctx, f, c)
+	})
+}
+
+// SystemInfo asks the module for its product, firmware and serial number.
+func (r *Module) SystemInfo() (SystemInfo, error) {
+	return r.SystemInfoContext(context.Background())
+}
+
+// SystemInfoContext is SystemInfo that gives up when ctx is done. A
+// replayed capture answers with the system information it holds, a
+// recorded Framer also writes the answer to its capture.
+func (r *Module) SystemInfoContext(ctx context.Context) (SystemInfo, error) {
+	if c, ok := r.f.(interface {
+		capturedSystemInfo() (SystemInfo, bool)
+	}); ok {
+		if s, ok := c.capturedSystemInfo(); ok {
+			return s, nil
+		}
+	}
+	s, err := readSystemInfo(func(c Command[string]) (string, error) {
+		return query(ctx, r, c.Name, c.Payload, c.Reply)
+	})
+	if err != nil {
+		return SystemInfo{}, err
+	}
+	if w, ok := r.f.(interface{ recordSystemInfo(SystemInfo) }); ok {
+		w.recordSystemInfo(s)
+	}
+	return s, nil
+}
@@ -0,0 +1,49 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSystemInfo(t *testing.T) {
+	expected := SystemInfo{Product: "X2M200", ItemNumber: "1234", FirmwareID: "X2M200", Version: "1.3.4", Build: "2017-01-01", SerialNumber: "SN5678"}
+	items := []string{"1234", "X2M200", "X2M200", "1.3.4", "2017-01-01", "", "SN5678"}
+	x := (&fakeDevice{reply: func(n int, _ []byte) []byte {
+		code := byte(n)
+		if n >= 5 {
+			code++
+		}
+		return append([]byte{x2m200Reply, x2m200SystemInfo, code}, items[code]+"\x00"...)
+	}}).open("x2m200")
+	var capture bytes.Buffer
+	w, _ := NewCaptureWriter(&capture)
+	m := NewModule(Record(x, w), "respiration")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s, err := m.SystemInfoContext(ctx)
+	if s != expected || err != nil {
+		t.Fatalf("Expected: %+v %v, got %+v %v\n", expected, nil, s, err)
+	}
+	x.Close()
+
+	// the capture carries it
+	f, err := NewReplay(bytes.NewReader(capture.Bytes()), ReplayAsFastAsPossible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewModule(f, "respiration").SystemInfoContext(ctx); s != expected || err != nil {
+		t.Errorf("Expected: %+v %v, got %+v %v\n", expected, nil, s, err)
+	}
+}
+
+func TestSystemInfoNotSupported(t *testing.T) {
+	m := (&fakeDevice{reply: func(int, []byte) []byte {
+		return []byte{errorByte, ProtocolErrorNotRecognised}
+	}}).module(t, "respiration")
+	if _, err := m.SystemInfo(); !errors.Is(err, ErrCommandNotRecognised) {
+		t.Errorf("Expected: %v, got %v\n", ErrCommandNotRecognised, err)
+	}
+}
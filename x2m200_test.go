@@ -21,6 +21,7 @@ package xethru
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"testing"
@@ -29,16 +30,16 @@ import (
 
 // testing helper
 func NewXethruWriter(w io.Writer) io.Writer {
-	return &x2m200Frame{w: w}
+	return newX2M200Frame(w, nil, nil)
 }
 
 func NewXethruReader(r io.Reader) io.Reader {
-	return &x2m200Frame{r: bufio.NewReader(r)}
+	return newX2M200Frame(nil, r, nil)
 }
 
 // CreateSplitReadWriter Used help with testing Framer
 func CreateSplitReadWriter(w io.Writer, r io.Reader) Framer {
-	return &x2m200Frame{w: w, r: bufio.NewReader(r)}
+	return newX2M200Frame(w, r, nil)
 }
 
 func x2m200ProtocolwithTransit(in []byte) ([]byte, []byte, error) {
@@ -69,14 +70,15 @@ func TestX2M200Write(t *testing.T) {
 		err    error
 		writen []byte
 	}{
-		{[]byte{0x01, 0x02, 0x00}, 6, nil, []byte{0x7d, 0x01, 0x02, 0x00, 0x7e, 0x7e}},
-		{[]byte{0x00, 0x7c, 0x7f}, 6, nil, []byte{0x7d, 0x00, 0x7c, 0x7f, 0x7e, 0x7e}},
-		{[]byte{0x01, 0x02, 0x03}, 6, nil, []byte{0x7d, 0x01, 0x02, 0x03, 0x7d, 0x7e}},
-		{[]byte{0x00, 0x01, 0x02, 0x03}, 7, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x03, 0x7d, 0x7e}},
+		{[]byte{0x01, 0x02, 0x00}, 7, nil, []byte{0x7d, 0x01, 0x02, 0x00, 0x7f, 0x7e, 0x7e}},
+		{[]byte{0x00, 0x7c, 0x7f}, 8, nil, []byte{0x7d, 0x00, 0x7c, 0x7f, 0x7f, 0x7f, 0x7e, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, 7, nil, []byte{0x7d, 0x01, 0x02, 0x03, 0x7f, 0x7d, 0x7e}},
+		{[]byte{0x00, 0x01, 0x02, 0x03}, 8, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x03, 0x7f, 0x7d, 0x7e}},
 		{[]byte{0x00, 0x01, 0x02, 0x7e}, 8, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x7f, 0x7e, 0x00, 0x7e}},
-		{[]byte{0x7e, 0x01, 0x02, 0x7e}, 9, nil, []byte{0x7d, 0x7f, 0x7e, 0x01, 0x02, 0x7f, 0x7e, 0x7e, 0x7e}},
+		{[]byte{0x7e, 0x01, 0x02, 0x7e}, 10, nil, []byte{0x7d, 0x7f, 0x7e, 0x01, 0x02, 0x7f, 0x7e, 0x7f, 0x7e, 0x7e}},
 		{[]byte{0x7e, 0x7e, 0x02, 0x7e}, 10, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x02, 0x7f, 0x7e, 0x01, 0x7e}},
-		{[]byte{0x7e, 0x7e, 0x7e, 0x7e}, 11, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7d, 0x7e}},
+		{[]byte{0x7e, 0x7e, 0x7e, 0x7e}, 12, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7d, 0x7e}},
+		{[]byte{0x7d, 0x7f}, 8, nil, []byte{0x7d, 0x7f, 0x7d, 0x7f, 0x7f, 0x7f, 0x7f, 0x7e}},
 		{[]byte{0x01, 0xee, 0xaa, 0xea, 0xae}, 8, nil, []byte{0x7d, 0x01, 0xee, 0xaa, 0xea, 0xae, 0x7c, 0x7e}},
 	}
 	for _, c := range cases {
@@ -107,20 +109,22 @@ func TestX2M200Read(t *testing.T) {
 	}{
 		{[]byte{0x01, 0x02, 0x00}, nil, []byte{0x7d, 0x01, 0x02, 0x00, 0x7f, 0x7e, 0x7e}},
 		{[]byte{0x01, 0x02, 0x03}, nil, []byte{0x7d, 0x01, 0x02, 0x03, 0x7f, 0x7d, 0x7e}},
-		{[]byte{0x00, 0x7c, 0x7f}, nil, []byte{0x7d, 0x00, 0x7c, 0x7f, 0x7f, 0x7e, 0x7e}},
-		{[]byte{0x00, 0x01, 0x02, 0x03}, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x03, 0x7d, 0x7e}},
+		{[]byte{0x00, 0x7c, 0x7f}, nil, []byte{0x7d, 0x00, 0x7c, 0x7f, 0x7f, 0x7f, 0x7e, 0x7e}},
+		{[]byte{0x00, 0x01, 0x02, 0x03}, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x03, 0x7f, 0x7d, 0x7e}},
 		{[]byte{0x00, 0x01, 0x02, 0x7e}, nil, []byte{0x7d, 0x00, 0x01, 0x02, 0x7f, 0x7e, 0x00, 0x7e}},
-		{[]byte{0x7e, 0x01, 0x02, 0x7e}, nil, []byte{0x7d, 0x7f, 0x7e, 0x01, 0x02, 0x7f, 0x7e, 0x7e, 0x7e}},
+		{[]byte{0x7e, 0x01, 0x02, 0x7e}, nil, []byte{0x7d, 0x7f, 0x7e, 0x01, 0x02, 0x7f, 0x7e, 0x7f, 0x7e, 0x7e}},
 		{[]byte{0x7e, 0x7e, 0x02, 0x7e}, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x02, 0x7f, 0x7e, 0x01, 0x7e}},
-		{[]byte{0x7e, 0x7e, 0x7e, 0x7e}, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7d, 0x7e}},
-		{[]byte{0x01, 0x02, 0x03}, errPacketNoStartByte, []byte{0x1d, 0x01, 0x02, 0x03, 0x7d, 0x7e}},
+		{[]byte{0x7e, 0x7e, 0x7e, 0x7e}, nil, []byte{0x7d, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7e, 0x7f, 0x7d, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrBadEscape, []byte{0x7d, 0x01, 0x7f, 0x02, 0x03, 0x7f, 0x7d, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrNoStartByte, []byte{0x1d, 0x01, 0x02, 0x03, 0x7d, 0x7e}},
 		{[]byte{}, io.EOF, []byte{}},
 		{[]byte{}, io.EOF, []byte{0x7d}},
-		{[]byte{0x01, 0x02, 0x03}, errPacketBadCRC, []byte{0x7d, 0x01, 0x02, 0x03, 0x71, 0x7e}},
-		{[]byte{0x01, 0x02, 0x03}, errProtocolErrorNotReconsied, []byte{0x7d, 0x20, 0x01, 0x5c, 0x7e}},
-		{[]byte{0x01, 0x02, 0x03}, errProtocolErrorCRCfailed, []byte{0x7d, 0x20, 0x02, 0x5f, 0x7e}},
-		{[]byte{0x01, 0x02, 0x03}, errProtocolErrorInvaidAppID, []byte{0x7d, 0x20, 0x03, 0x5e, 0x7e}},
-		{[]byte{}, nil, []byte{0x7d, 0x7d, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrBadCRC, []byte{0x7d, 0x01, 0x02, 0x03, 0x71, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrCommandNotRecognised, []byte{0x7d, 0x20, 0x01, 0x5c, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrCommandCRCFailed, []byte{0x7d, 0x20, 0x02, 0x5f, 0x7e}},
+		{[]byte{0x01, 0x02, 0x03}, ErrInvalidAppID, []byte{0x7d, 0x20, 0x03, 0x5e, 0x7e}},
+		{[]byte{}, nil, []byte{0x7d, 0x7f, 0x7d, 0x7e}},
+		{[]byte{}, ErrFrameTooShort, []byte{0x7d, 0x7e}},
 	}
 
 	for _, c := range cases {
@@ -131,7 +135,7 @@ func TestX2M200Read(t *testing.T) {
 		n, err := x.Read(b)
 		readback := b[:n]
 
-		if err != c.err {
+		if !errors.Is(err, c.err) {
 			t.Errorf("Expected: %s, got %s\n", c.err, err)
 		}
 
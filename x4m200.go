@@ -0,0 +1,119 @@
+package xethru
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Statuses of the messages that are only sent by X4 modules.
+const (
+	respMovingList     status = 0x610a3b00
+	presenceSingle     status = 0x723bfa1e
+	presenceMovingList status = 0x723bfa1f
+)
+
+// X4 outputs are turned on and off by the status of their messages.
+// Example: <Start> + <XTS_SPC_OUTPUT> + <XTS_SPCO_SETCONTROL> + [ID(i)] + [Control(i)] + <CRC> + <End>
+// Response: <Start> + <XTS_SPR_ACK> + <CRC> + <End>
+const (
+	x4OutputCommand    = 0x41
+	x4OutputSetControl = 0x10
+)
+
+func x4Output(id uint32, code byte) []byte {
+	p := []byte{x4OutputCommand, x4OutputSetControl, 0, 0, 0, 0, code, 0, 0, 0}
+	binary.LittleEndian.PutUint32(p[2:6], id)
+	return p
+}
+
+// x4Baseband turns baseband on as the other outputs, so turning it off
+// takes both of them.
+func x4Baseband(mode string) [][]byte {
+	switch mode {
+	case "phase":
+		return [][]byte{x4Output(uint32(basebandIQ), 0), x4Output(uint32(basebandAP), 1)}
+	case "iq":
+		return [][]byte{x4Output(uint32(basebandAP), 0), x4Output(uint32(basebandIQ), 1)}
+	}
+	return [][]byte{x4Output(uint32(basebandIQ), 0), x4Output(uint32(basebandAP), 0)}
+}
+
+// The X4M200 runs the respiration profiles, version 2 as "respiration" and
+// version 3 as "respiration3", and the sleep profile. They send Respiration,
+// Sleep and RespirationMovingList messages.
+var x4m200Driver = &driver{
+	name: "x4m200",
+	apps: map[string][4]byte{
+		"respiration":  {0xad, 0x57, 0x4e, 0x06},
+		"respiration3": {0xba, 0xbe, 0xfa, 0x47},
+		"sleep":        {0x17, 0x7b, 0xf1, 0x00},
+	},
+	parse: func(b []byte) (interface{}, error) {
+		return parseWith(b, parseX4M200Message)
+	},
+	output:   x4Output,
+	baseband: x4Baseband,
+	disable:  x4Baseband(""),
+}
+
+// RespirationMovingList is the movement in each range interval of the
+// detection zone, sent by the X4M200 respiration profiles.
+type RespirationMovingList struct {
+	Time         int64            `json:"time"`
+	Status       status           `json:"type"`
+	Counter      uint32           `json:"counter"`
+	State        respirationState `json:"state"`
+	MovementSlow []float64        `json:"movementslow"`
+	MovementFast []float64        `json:"movementfast"`
+}
+
+// parseX4M200Message tells app messages apart by their whole status, the
+// rest is as on the X2M200.
+func parseX4M200Message(b []byte) (interface{}, error) {
+	if len(b) == 0 || b[0] != appDataByte {
+		return parseMessage(b)
+	}
+	if len(b) < 5 {
+		return nil, ErrPayloadTooShort
+	}
+	switch status(binary.LittleEndian.Uint32(b[1:5])) {
+	case respApp:
+		return parseRespiration(b)
+	case sleepApp:
+		return parseSleep(b)
+	case respMovingList:
+		return parseRespirationMovingList(b)
+	case basebandAP:
+		return parseBaseBandAP(b)
+	case basebandIQ:
+		return parseBaseBandIQ(b)
+	}
+	return nil, ErrParseNotImplemented
+}
+
+// movingListHeaderSize is followed by two blocks of float32, slow then
+// fast movement, of the interval count at [13:17].
+const movingListHeaderSize = 17
+
+func parseRespirationMovingList(b []byte) (RespirationMovingList, error) {
+	if len(b) < movingListHeaderSize {
+		return RespirationMovingList{}, ErrPayloadTooShort
+	}
+	var l RespirationMovingList
+	l.Time = time.Now().UnixNano()
+	l.Status = status(binary.LittleEndian.Uint32(b[1:5]))
+	l.Counter = binary.LittleEndian.Uint32(b[5:9])
+	l.State = respirationState(binary.LittleEndian.Uint32(b[9:13]))
+	n := binary.LittleEndian.Uint32(b[13:17])
+	if err := checkBins(b, movingListHeaderSize, n); err != nil {
+		return l, err
+	}
+	l.MovementSlow = float32s(b[movingListHeaderSize:], n)
+	l.MovementFast = float32s(b[movingListHeaderSize+4*int(n):], n)
+	return l, nil
+}
+
+func float32At(b []byte) float64 {
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+}
@@ -0,0 +1,226 @@
+package xethru
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// x4Message builds an app message with status followed by the values,
+// floats go as float32 and the rest as uint32 except bytes.
+func x4Message(s status, values ...interface{}) []byte {
+	b := []byte{appDataByte, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[1:], uint32(s))
+	for _, v := range values {
+		switch v := v.(type) {
+		case float64:
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v)))
+		case byte:
+			b = append(b, v)
+		case int:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		}
+	}
+	return b
+}
+
+func TestParseX4(t *testing.T) {
+	cases := []struct {
+		drv      *driver
+		b        []byte
+		expected interface{}
+		err      error
+	}{
+		{x4m200Driver, x4Message(respApp, 7, 0, 12, 1.5, 0.25, 9), Respiration{Status: respApp, Counter: 7, RPM: 12, Distance: 1.5, Movement: 0.25, SignalQuality: 9}, nil},
+		{x4m200Driver, x4Message(sleepApp, 8, 2, 11.5, 1.25, 10, 0.5, 0.75), Sleep{Status: sleepApp, Counter: 8, State: 2, RPM: 11.5, Distance: 1.25, SignalQuality: 10, MovementSlow: 0.5, MovementFast: 0.75}, nil},
+		{x4m200Driver, x4Message(respMovingList, 9, 0, 2, 0.5, 0.25, 1.5, 1.25), RespirationMovingList{Status: respMovingList, Counter: 9, MovementSlow: []float64{0.5, 0.25}, MovementFast: []float64{1.5, 1.25}}, nil},
+		{x4m200Driver, x4Message(respMovingList, 9, 0, 2, 0.5), nil, ErrPayloadIncomplete},
+		{x4m200Driver, x4Message(presenceSingle, 1, 1, 2.5, byte(1), 8), nil, ErrParseNotImplemented},
+		{x4m300Driver, x4Message(presenceSingle, 1, 1, 2.5, byte(1), 8), PresenceSingle{Status: presenceSingle, Counter: 1, State: presenceDetected, Distance: 2.5, Direction: 1, SignalQuality: 8}, nil},
+		{x4m300Driver, x4Message(presenceSingle, 1, 1), nil, ErrPayloadTooShort},
+		{x4m300Driver, x4Message(presenceMovingList, 3, 2, 1, 0.5, 0.25), PresenceMovingList{Status: presenceMovingList, Counter: 3, State: presenceInitializing, MovementSlow: []float64{0.5}, MovementFast: []float64{0.25}}, nil},
+		{x4m300Driver, []byte{systemMesg, systemReady}, SystemMessage{Message: "System Ready"}, nil},
+		// the X2M200 only looks at the first byte of the status
+		{x2m200Driver, x4Message(respMovingList, 9, 0, 0), nil, ErrParseNotImplemented},
+	}
+	for n, c := range cases {
+		v, err := c.drv.parse(c.b)
+		if !errors.Is(err, c.err) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, c.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		// the parsers stamp the time
+		switch m := v.(type) {
+		case Respiration:
+			m.Time = 0
+			v = m
+		case Sleep:
+			m.Time = 0
+			v = m
+		case RespirationMovingList:
+			m.Time = 0
+			v = m
+		case PresenceSingle:
+			m.Time = 0
+			v = m
+		case PresenceMovingList:
+			m.Time = 0
+			v = m
+		}
+		if !reflect.DeepEqual(v, c.expected) {
+			t.Errorf("test %d Expected: %+v, got %+v\n", n, c.expected, v)
+		}
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	for n, s := range []status{respMovingList, presenceSingle, presenceMovingList} {
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		var got status
+		if err := json.Unmarshal(b, &got); err != nil || got != s {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, s, nil, got, err)
+		}
+	}
+}
+
+// Module code does not change from one family to the other, only the
+// device given to Open.
+func TestX4Module(t *testing.T) {
+	cases := []struct {
+		device   string
+		mode     string
+		frame    []byte
+		expected [][]byte
+		check    func(v interface{}) bool
+	}{
+		{"x4m300", "presence", x4Message(presenceSingle, 1, 1, 2.5, byte(0), 8), [][]byte{
+			{0x41, 0x10, 0x0c, 0, 0, 0, 0, 0, 0, 0},
+			{0x41, 0x10, 0x0d, 0, 0, 0, 0, 0, 0, 0},
+			{0x20, 0x11},
+			{resetCmd},
+			{x2m200LoadModule, 0xb8, 0x4a, 0x4d, 0x01},
+			{0x41, 0x10, 0x0c, 0, 0, 0, 0, 0, 0, 0},
+			{0x41, 0x10, 0x0d, 0, 0, 0, 1, 0, 0, 0},
+			{0x20, 0x01},
+		}, func(v interface{}) bool {
+			p, ok := v.(PresenceSingle)
+			return ok && p.Distance == 2.5
+		}},
+		{"X4M200", "sleep", x4Message(sleepApp, 8, 2, 11.5, 1.25, 10, 0.5, 0.75), [][]byte{
+			{0x41, 0x10, 0x0c, 0, 0, 0, 0, 0, 0, 0},
+			{0x41, 0x10, 0x0d, 0, 0, 0, 0, 0, 0, 0},
+			{0x20, 0x11},
+			{resetCmd},
+			{x2m200LoadModule, 0x17, 0x7b, 0xf1, 0x00},
+			{0x41, 0x10, 0x0c, 0, 0, 0, 0, 0, 0, 0},
+			{0x41, 0x10, 0x0d, 0, 0, 0, 1, 0, 0, 0},
+			{0x20, 0x01},
+		}, func(v interface{}) bool {
+			s, ok := v.(Sleep)
+			return ok && s.RPM == 11.5
+		}},
+	}
+	for n, c := range cases {
+		d := &fakeDevice{frame: func(uint32) []byte { return c.frame }}
+		f := d.open(c.device)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if ok, err := f.(*x2m200Frame).ResetContext(ctx); !ok || err != nil {
+			t.Fatalf("test %d Expected: %v %v, got %v %v\n", n, true, nil, ok, err)
+		}
+		m := NewModule(f, c.mode)
+		if err := m.LoadContext(ctx); err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		if err := m.EnableContext(ctx, "phase"); err != nil {
+			t.Fatalf("test %d Expected: %v, got %v\n", n, nil, err)
+		}
+		rctx, rcancel := context.WithCancel(ctx)
+		stream := make(chan interface{})
+		done := make(chan error)
+		go func() { done <- m.RunContext(rctx, stream) }()
+		if v := <-stream; !c.check(v) {
+			t.Errorf("test %d Expected: %s data, got %+v\n", n, c.device, v)
+		}
+		rcancel()
+		<-done
+		if cmds := d.commands()[:len(c.expected)]; !reflect.DeepEqual(cmds, c.expected) {
+			t.Errorf("test %d Expected: % x, got % x\n", n, c.expected, cmds)
+		}
+		cancel()
+		f.Close()
+	}
+}
+
+func TestNewModuleMode(t *testing.T) {
+	cases := []struct {
+		device string
+		mode   string
+		app    [4]byte
+	}{
+		{"x2m200", "basebandiq", [4]byte{0x14, 0x23, 0xa2, 0xd6}},
+		{"x4m200", "respiration3", [4]byte{0xba, 0xbe, 0xfa, 0x47}},
+		{"x4m300", "presence", [4]byte{0xb8, 0x4a, 0x4d, 0x01}},
+	}
+	for n, c := range cases {
+		if m := NewModule(Open(c.device, rwc{}), c.mode); m.AppID != c.app {
+			t.Errorf("test %d Expected: % x, got % x\n", n, c.app, m.AppID)
+		}
+	}
+}
+
+// Load does not send app ID 0 for a mode the device has no app for.
+func TestLoadUnknownMode(t *testing.T) {
+	cases := []struct {
+		device string
+		mode   string
+	}{
+		{"x2m200", "presence"},
+		{"x4m200", "basebandampphase"},
+		{"x4m300", "respiration"},
+	}
+	for n, c := range cases {
+		out := new(bytes.Buffer)
+		m := NewModule(Open(c.device, rwc{bytes.NewReader(nil), out}), c.mode)
+		if err := m.Load(); !errors.Is(err, ErrUnknownMode) {
+			t.Errorf("test %d Expected: %v, got %v\n", n, ErrUnknownMode, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("test %d Expected: nothing sent, got % x\n", n, out.Bytes())
+		}
+	}
+}
+
+func TestPresenceStateJSON(t *testing.T) {
+	cases := []struct {
+		s        presenceState
+		expected string
+	}{
+		{noPresence, `"noPresence"`},
+		{presenceDetected, `"presenceDetected"`},
+		{presenceInitializing, `"presenceInitializing"`},
+	}
+	for n, c := range cases {
+		b, err := json.Marshal(c.s)
+		if err != nil || string(b) != c.expected {
+			t.Fatalf("test %d Expected: %v %v, got %s %v\n", n, c.expected, nil, b, err)
+		}
+		var got presenceState
+		if err := json.Unmarshal(b, &got); err != nil || got != c.s {
+			t.Errorf("test %d Expected: %v %v, got %v %v\n", n, c.s, nil, got, err)
+		}
+	}
+	if s := presenceState(7).String(); s != "presenceState(7)" {
+		t.Errorf("Expected: %v, got %v\n", "presenceState(7)", s)
+	}
+}
@@ -0,0 +1,104 @@
+package xethru
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+type presenceState uint32
+
+//go:generate jsonenums -type=presenceState
+//go:generate stringer -type=presenceState
+const (
+	noPresence           presenceState = 0
+	presenceDetected     presenceState = 1
+	presenceInitializing presenceState = 2
+)
+
+// The X4M300 runs the presence profile, it sends PresenceSingle and
+// PresenceMovingList messages.
+var x4m300Driver = &driver{
+	name: "x4m300",
+	apps: map[string][4]byte{
+		"presence": {0xb8, 0x4a, 0x4d, 0x01},
+	},
+	parse: func(b []byte) (interface{}, error) {
+		return parseWith(b, parseX4M300Message)
+	},
+	output:   x4Output,
+	baseband: x4Baseband,
+	disable:  x4Baseband(""),
+}
+
+// PresenceSingle is the closest presence the X4M300 detects.
+type PresenceSingle struct {
+	Time          int64         `json:"time"`
+	Status        status        `json:"type"`
+	Counter       uint32        `json:"counter"`
+	State         presenceState `json:"state"`
+	Distance      float64       `json:"distance"`
+	Direction     byte          `json:"direction"`
+	SignalQuality float64       `json:"signalquality"`
+}
+
+// PresenceMovingList is the movement in each range interval of the
+// detection zone, sent by the X4M300.
+type PresenceMovingList struct {
+	Time         int64         `json:"time"`
+	Status       status        `json:"type"`
+	Counter      uint32        `json:"counter"`
+	State        presenceState `json:"state"`
+	MovementSlow []float64     `json:"movementslow"`
+	MovementFast []float64     `json:"movementfast"`
+}
+
+func parseX4M300Message(b []byte) (interface{}, error) {
+	if len(b) == 0 || b[0] != appDataByte {
+		return parseMessage(b)
+	}
+	if len(b) < 5 {
+		return nil, ErrPayloadTooShort
+	}
+	switch status(binary.LittleEndian.Uint32(b[1:5])) {
+	case presenceSingle:
+		return parsePresenceSingle(b)
+	case presenceMovingList:
+		return parsePresenceMovingList(b)
+	case basebandAP:
+		return parseBaseBandAP(b)
+	case basebandIQ:
+		return parseBaseBandIQ(b)
+	}
+	return nil, ErrParseNotImplemented
+}
+
+const presenceSingleSize = 22
+
+func parsePresenceSingle(b []byte) (PresenceSingle, error) {
+	if err := checkSize(b, presenceSingleSize); err != nil {
+		return PresenceSingle{}, err
+	}
+	var p PresenceSingle
+	p.Time = time.Now().UnixNano()
+	p.Status = status(binary.LittleEndian.Uint32(b[1:5]))
+	p.Counter = binary.LittleEndian.Uint32(b[5:9])
+	p.State = presenceState(binary.LittleEndian.Uint32(b[9:13]))
+	p.Distance = float32At(b[13:17])
+	p.Direction = b[17]
+	p.SignalQuality = float64(binary.LittleEndian.Uint32(b[18:22]))
+	return p, nil
+}
+
+// parsePresenceMovingList reads the same layout as
+// parseRespirationMovingList.
+func parsePresenceMovingList(b []byte) (PresenceMovingList, error) {
+	l, err := parseRespirationMovingList(b)
+	return PresenceMovingList{
+		Time:         l.Time,
+		Status:       l.Status,
+		Counter:      l.Counter,
+		State:        presenceState(l.State),
+		MovementSlow: l.MovementSlow,
+		MovementFast: l.MovementFast,
+	}, err
+}
@@ -23,22 +23,18 @@
 package xethru
 
 import (
-	"bufio"
+	"context"
 	"io"
 	"time"
 )
 
-// Open Creates a x2m200 xethu serial protocol from a io.ReadWriter
-// it implements io.Reader and io.Writer
-func Open(device string, port io.ReadWriteCloser) Framer {
-	// fmt.Println("New instance of Xethru")
-	// if device == "x2m200" {
-	x := &x2m200Frame{
-		w: port,
-		r: bufio.NewReader(port),
-		c: port,
-	}
-	// TODO: disable all feeds
+// Open Creates a xethu serial protocol from a io.ReadWriter
+// it implements io.Reader and io.Writer. device is the module on the other
+// end, "x2m200", "x4m200" or "x4m300", it picks the app IDs and the
+// messages a Module uses. Any other device is taken for an X2M200.
+func Open(device string, port io.ReadWriteCloser, opts ...Option) Framer {
+	x := newX2M200Frame(port, port, port, opts...)
+	x.drv = lookupDriver(device)
 	return x
 }
 
@@ -47,17 +43,33 @@ type Framer interface {
 	io.Writer
 	io.Reader
 	io.Closer
+	// ReadFrame returns the next frame without copying it, the frame must
+	// be released once it is no longer needed.
+	ReadFrame() (*Frame, error)
+	// ReadFrameContext and WriteFrameContext give up when ctx is done.
+	ReadFrameContext(ctx context.Context) (*Frame, error)
+	WriteFrameContext(ctx context.Context, p []byte) (int, error)
+	// Stats reports the link level counters, it may be called concurrently
+	// with reads and writes.
+	Stats() FrameStats
 	Reset() (bool, error)
+	ResetContext(ctx context.Context) (bool, error)
 }
 
 type Module struct {
 	f                  Framer
+	d                  *Demux
+	drv                *driver
+	modeErr            error // no app for the mode, Load returns it
 	AppID              [4]byte
 	LEDMode            ledMode
 	DetectionZoneStart float32
 	DetectionZoneEnd   float32
 	Sensitivity        uint32
-	Timeout            time.Duration
-	Data               chan interface{}
+	// Timeout bounds each attempt of a command, which is sent up to
+	// Retries more times before giving up.
+	Timeout time.Duration
+	Retries int
+	Data    chan interface{}
 	// parser             func(b []byte) (interface{}, error)
 }
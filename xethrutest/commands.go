@@ -0,0 +1,318 @@
+package xethrutest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+// Protocol bytes, as seen from the module.
+const (
+	ack           = 0x10
+	reply         = 0x14
+	errorByte     = 0x20
+	systemMesg    = 0x30
+	systemBooting = 0x10
+	systemReady   = 0x11
+	appDataByte   = 0x50
+	pingSeed      = 0xeeaaeaae
+	pingReady     = 0xaaeeaeea
+	pingNotReady  = 0xaeeaeeaa
+	statusResp    = 594935334
+	statusSleep   = 594911596
+	statusIQ      = 0x0c
+	statusAP      = 0x0d
+	statusRML     = 0x610a3b00
+	statusPS      = 0x723bfa1e
+	statusPML     = 0x723bfa1f
+)
+
+// App IDs sent by NewModule, the X4 ones by a Module on a Framer opened
+// for an "x4m200" or "x4m300". The X4M200 sleep profile is SleepApp.
+var (
+	RespirationApp    = [4]byte{0xd6, 0xa2, 0x23, 0x14}
+	SleepApp          = [4]byte{0x17, 0x7b, 0xf1, 0x00}
+	X4RespirationApp  = [4]byte{0xad, 0x57, 0x4e, 0x06}
+	X4Respiration3App = [4]byte{0xba, 0xbe, 0xfa, 0x47}
+	X4PresenceApp     = [4]byte{0xb8, 0x4a, 0x4d, 0x01}
+)
+
+// Ping is the ping command.
+func Ping() []byte {
+	b := []byte{0x01, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], pingSeed)
+	return b
+}
+
+func pingReply(r uint32) []byte {
+	b := []byte{0x01, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], r)
+	return b
+}
+
+// Reset is the reset command.
+func Reset() []byte {
+	return []byte{0x22}
+}
+
+// Load is the command loading app.
+func Load(app [4]byte) []byte {
+	return []byte{0x21, app[0], app[1], app[2], app[3]}
+}
+
+// SetLEDControl is the command sent by SetLEDMode.
+func SetLEDControl(mode byte) []byte {
+	return []byte{0x24, mode, 0x00}
+}
+
+// SetDetectionZone is the command sent by SetDetectionZone.
+func SetDetectionZone(start, end float32) []byte {
+	b := []byte{0x10, 0x10, 0x1c, 0x0a, 0xa1, 0x96, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[6:], math.Float32bits(start))
+	binary.LittleEndian.PutUint32(b[10:], math.Float32bits(end))
+	return b
+}
+
+// SetSensitivity is the command sent by SetSensitivity.
+func SetSensitivity(n uint32) []byte {
+	b := []byte{0x10, 0x10, 0x2b, 0x11, 0xa5, 0x10, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[6:], n)
+	return b
+}
+
+// SetBaudRate is the command sent by SetBaudRate.
+func SetBaudRate(baud uint32) []byte {
+	b := []byte{0x80, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[1:], baud)
+	return b
+}
+
+// GetLEDControl, GetDetectionZone and GetSensitivity are the commands sent
+// by the Module getters and Verify.
+func GetLEDControl() []byte {
+	return []byte{0x25}
+}
+
+// GetDetectionZone see GetLEDControl.
+func GetDetectionZone() []byte {
+	return []byte{0x10, 0x11, 0x1c, 0x0a, 0xa1, 0x96}
+}
+
+// GetSensitivity see GetLEDControl.
+func GetSensitivity() []byte {
+	return []byte{0x10, 0x11, 0x2b, 0x11, 0xa5, 0x10}
+}
+
+// GetSystemInfo is the command asking for the system info item code, see
+// Device.ExpectSystemInfo.
+func GetSystemInfo(code byte) []byte {
+	return []byte{0x30, code}
+}
+
+// SystemInfoReply is the answer to GetSystemInfo.
+func SystemInfoReply(code byte, info string) []byte {
+	return append(append([]byte{reply, 0x30, code}, info...), 0)
+}
+
+// LEDControlReply, DetectionZoneReply and SensitivityReply are the answers
+// to the get commands, for Expectation.Reply.
+func LEDControlReply(mode byte) []byte {
+	return []byte{reply, 0x25, mode}
+}
+
+// DetectionZoneReply see LEDControlReply.
+func DetectionZoneReply(start, end float32) []byte {
+	b := append([]byte{reply}, GetDetectionZone()[1:]...)
+	return append(b, SetDetectionZone(start, end)[6:]...)
+}
+
+// SensitivityReply see LEDControlReply.
+func SensitivityReply(n uint32) []byte {
+	b := append([]byte{reply}, GetSensitivity()[1:]...)
+	return append(b, SetSensitivity(n)[6:]...)
+}
+
+// StoreNoiseMap, LoadNoiseMap, DeleteNoiseMap and RelearnNoiseMap are the
+// noise map commands of the Module methods of the same name.
+func StoreNoiseMap() []byte {
+	return []byte{0x65, 0x02}
+}
+
+// LoadNoiseMap see StoreNoiseMap.
+func LoadNoiseMap() []byte {
+	return []byte{0x65, 0x03}
+}
+
+// DeleteNoiseMap see StoreNoiseMap.
+func DeleteNoiseMap() []byte {
+	return []byte{0x65, 0x13}
+}
+
+// RelearnNoiseMap see StoreNoiseMap.
+func RelearnNoiseMap() []byte {
+	return []byte{0x65, 0x04}
+}
+
+// SetNoiseMapControl is the command sent by SetNoiseMapControl.
+func SetNoiseMapControl(c xethru.NoiseMapControl) []byte {
+	b := []byte{0x65, 0x10, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[2:], uint32(c))
+	return b
+}
+
+// GetNoiseMapControl is the command sent by GetNoiseMapControl.
+func GetNoiseMapControl() []byte {
+	return []byte{0x65, 0x11}
+}
+
+// NoiseMapControlReply is the answer to GetNoiseMapControl.
+func NoiseMapControlReply(c xethru.NoiseMapControl) []byte {
+	b := []byte{reply, 0x65, 0x11, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[3:], uint32(c))
+	return b
+}
+
+// Run and Stop start and stop the loaded app.
+func Run() []byte {
+	return []byte{0x20, 0x01}
+}
+
+// Stop see Run.
+func Stop() []byte {
+	return []byte{0x20, 0x11}
+}
+
+// OutputBaseband is the command sent by Enable, id selects the output and
+// code turns it off (0), to I/Q (1) or to amplitude and phase (2).
+func OutputBaseband(id, code byte) []byte {
+	return []byte{0x90, 0x71, id, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, code, 0x00, 0x00, 0x00}
+}
+
+// SleepOutput and RespirationOutput are the commands sent by
+// SetSleepOutput and SetRespirationOutput.
+func SleepOutput(on bool) []byte {
+	return appOutput(statusSleep, on)
+}
+
+// RespirationOutput see SleepOutput.
+func RespirationOutput(on bool) []byte {
+	return appOutput(statusResp, on)
+}
+
+func appOutput(status uint32, on bool) []byte {
+	b := OutputBaseband(0, 0)
+	binary.LittleEndian.PutUint32(b[2:6], status)
+	if on {
+		b[10] = 1
+	}
+	return b
+}
+
+// X4Output is the command turning the output of the messages with status
+// id on or off on an X4 module. Reset on an X4 turns off I/Q (0x0c) then
+// amplitude and phase (0x0d) baseband before the Reset command.
+func X4Output(id uint32, on bool) []byte {
+	b := []byte{0x41, 0x10, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[2:6], id)
+	if on {
+		b[6] = 1
+	}
+	return b
+}
+
+// DisableBaseband is the first command sent by Reset.
+func DisableBaseband() []byte {
+	return OutputBaseband(0x10, 0)
+}
+
+// Payload returns the data message the module sends for v, the reverse of
+// the driver's parsers. A zero Status is set to the one of the message.
+func Payload(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case xethru.Respiration:
+		b := header(29, uint32(v.Status), statusResp, v.Counter)
+		binary.LittleEndian.PutUint32(b[9:13], uint32(v.State))
+		binary.LittleEndian.PutUint32(b[13:17], v.RPM)
+		putFloat(b[17:21], v.Distance)
+		putFloat(b[21:25], v.Movement)
+		binary.LittleEndian.PutUint32(b[25:29], uint32(v.SignalQuality))
+		return b, nil
+	case xethru.Sleep:
+		b := header(33, uint32(v.Status), statusSleep, v.Counter)
+		binary.LittleEndian.PutUint32(b[9:13], uint32(v.State))
+		putFloat(b[13:17], v.RPM)
+		putFloat(b[17:21], v.Distance)
+		binary.LittleEndian.PutUint32(b[21:25], uint32(v.SignalQuality))
+		putFloat(b[25:29], v.MovementSlow)
+		putFloat(b[29:33], v.MovementFast)
+		return b, nil
+	case xethru.BaseBandAmpPhase:
+		return baseband(uint32(v.Status), statusAP, v.Counter, v.BinLength, v.SamplingFreq, v.CarrierFreq, v.RangeOffset, v.Amplitude, v.Phase)
+	case xethru.RespirationMovingList:
+		return movingList(uint32(v.Status), statusRML, v.Counter, uint32(v.State), v.MovementSlow, v.MovementFast)
+	case xethru.PresenceMovingList:
+		return movingList(uint32(v.Status), statusPML, v.Counter, uint32(v.State), v.MovementSlow, v.MovementFast)
+	case xethru.PresenceSingle:
+		b := header(22, uint32(v.Status), statusPS, v.Counter)
+		binary.LittleEndian.PutUint32(b[9:13], uint32(v.State))
+		putFloat(b[13:17], v.Distance)
+		b[17] = v.Direction
+		binary.LittleEndian.PutUint32(b[18:22], uint32(v.SignalQuality))
+		return b, nil
+	case xethru.BaseBandIQ:
+		return baseband(uint32(v.Status), statusIQ, v.Counter, v.BinLength, v.SamplingFreq, v.CarrierFreq, v.RangeOffset, v.SigI, v.SigQ)
+	}
+	return nil, fmt.Errorf("no payload for %T", v)
+}
+
+func header(n int, status, def, counter uint32) []byte {
+	if status == 0 {
+		status = def
+	}
+	b := make([]byte, n)
+	b[0] = appDataByte
+	binary.LittleEndian.PutUint32(b[1:5], status)
+	binary.LittleEndian.PutUint32(b[5:9], counter)
+	return b
+}
+
+func baseband(status, def, counter uint32, binLength, samplingFreq, carrierFreq, rangeOffset float64, first, second []float64) ([]byte, error) {
+	if len(first) != len(second) {
+		return nil, fmt.Errorf("baseband with %d and %d samples", len(first), len(second))
+	}
+	bins := len(first)
+	b := header(29+8*bins, status, def, counter)
+	binary.LittleEndian.PutUint32(b[9:13], uint32(bins))
+	putFloat(b[13:17], binLength)
+	putFloat(b[17:21], samplingFreq)
+	putFloat(b[21:25], carrierFreq)
+	putFloat(b[25:29], rangeOffset)
+	for i := range first {
+		putFloat(b[29+4*i:], first[i])
+		putFloat(b[29+4*(bins+i):], second[i])
+	}
+	return b, nil
+}
+
+func movingList(status, def, counter, state uint32, slow, fast []float64) ([]byte, error) {
+	if len(slow) != len(fast) {
+		return nil, fmt.Errorf("moving list with %d and %d intervals", len(slow), len(fast))
+	}
+	n := len(slow)
+	b := header(17+8*n, status, def, counter)
+	binary.LittleEndian.PutUint32(b[9:13], state)
+	binary.LittleEndian.PutUint32(b[13:17], uint32(n))
+	for i := range slow {
+		putFloat(b[17+4*i:], slow[i])
+		putFloat(b[17+4*(n+i):], fast[i])
+	}
+	return b, nil
+}
+
+func putFloat(b []byte, v float64) {
+	binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+}
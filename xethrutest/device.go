@@ -0,0 +1,311 @@
+// Package xethrutest provides a scripted module for unit tests of code
+// built on the xethru driver.
+//
+//	d := xethrutest.NewDevice(t)
+//	d.ExpectReset()
+//	d.Expect(xethrutest.Load(xethrutest.RespirationApp)).After(50 * time.Millisecond).ReplyAck()
+//	d.Expect(xethrutest.Run()).ReplyAck().Stream(xethru.Respiration{RPM: 12})
+//	x := xethru.Open("x2m200", d)
+//
+// Commands have to come in the order they are expected, anything else is
+// answered with a not recognised error frame and reported when the test
+// ends along with the expectations that were never met.
+package xethrutest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+// Device is the port side of xethru.Open: the driver writes commands to it
+// and reads the scripted replies. It supports read and write deadlines.
+type Device struct {
+	host, dev net.Conn
+
+	mu   sync.Mutex
+	exps []*Expectation
+	next int
+	errs []error
+	// steps waits for the writer, wake tells it there is more
+	steps []step
+	wake  chan struct{}
+
+	done      chan struct{}
+	read      chan struct{}
+	closeOnce sync.Once
+}
+
+// step is one reply, sent delay after the previous one.
+type step struct {
+	delay time.Duration
+	p     []byte
+}
+
+// NewDevice returns a Device that is closed and verified when the test
+// ends, the test fails if an expectation was not met.
+func NewDevice(t testing.TB) *Device {
+	host, dev := net.Pipe()
+	d := &Device{host: host, dev: dev, wake: make(chan struct{}, 1), done: make(chan struct{}), read: make(chan struct{})}
+	go d.readCommands()
+	go d.writeReplies()
+	t.Cleanup(func() {
+		d.Close()
+		if err := d.Verify(); err != nil {
+			t.Error(err)
+		}
+	})
+	return d
+}
+
+// Expect adds cmd, the payload of a command frame, to the commands the
+// device waits for. The returned Expectation scripts the replies.
+func (d *Device) Expect(cmd []byte) *Expectation {
+	e := &Expectation{cmd: append([]byte(nil), cmd...)}
+	d.mu.Lock()
+	d.exps = append(d.exps, e)
+	d.mu.Unlock()
+	return e
+}
+
+// ExpectReset expects what Reset sends to an X2M200 with no output on.
+func (d *Device) ExpectReset() {
+	d.Expect(DisableBaseband()).ReplyAck()
+	d.Expect(Stop()).ReplyAck()
+	d.Expect(Reset()).ReplyAck()
+}
+
+// ExpectSystemInfo expects the queries of Module.SystemInfo and answers
+// them with s.
+func (d *Device) ExpectSystemInfo(s xethru.SystemInfo) {
+	for _, item := range []struct {
+		code byte
+		v    string
+	}{
+		{0x00, s.ItemNumber},
+		{0x01, s.Product},
+		{0x02, s.FirmwareID},
+		{0x03, s.Version},
+		{0x04, s.Build},
+		{0x06, s.SerialNumber},
+	} {
+		d.Expect(GetSystemInfo(item.code)).Reply(SystemInfoReply(item.code, item.v))
+	}
+}
+
+// Verify reports the commands that did not match and the expectations
+// that were never met.
+func (d *Device) Verify() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	errs := append([]error(nil), d.errs...)
+	for _, e := range d.exps[d.next:] {
+		errs = append(errs, fmt.Errorf("expected command % x was not sent", e.cmd))
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Device) readCommands() {
+	defer close(d.read)
+	dec := xethru.NewFrameDecoder(d.dev)
+	for n := 0; ; n++ {
+		p, err := dec.Decode()
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			if errors.Is(err, xethru.ErrNoStartByte) || errors.Is(err, xethru.ErrBadCRC) ||
+				errors.Is(err, xethru.ErrBadEscape) || errors.Is(err, xethru.ErrFrameTooShort) {
+				d.fail(fmt.Errorf("command %d: %w", n, err))
+				continue
+			}
+			return
+		}
+		d.handle(n, p)
+	}
+}
+
+func (d *Device) handle(n int, p []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.next == len(d.exps) {
+		d.errs = append(d.errs, fmt.Errorf("command %d: unexpected % x", n, p))
+		d.queue(step{p: []byte{errorByte, xethru.ProtocolErrorNotRecognised}})
+		return
+	}
+	e := d.exps[d.next]
+	if string(e.cmd) != string(p) {
+		d.errs = append(d.errs, fmt.Errorf("command %d: expected % x, got % x", n, e.cmd, p))
+		d.queue(step{p: []byte{errorByte, xethru.ProtocolErrorNotRecognised}})
+		return
+	}
+	d.next++
+	e.mu.Lock()
+	steps, err := e.steps, e.err
+	e.mu.Unlock()
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("command %d % x: %w", n, p, err))
+	}
+	d.queue(steps...)
+}
+
+func (d *Device) fail(err error) {
+	d.mu.Lock()
+	d.errs = append(d.errs, err)
+	d.mu.Unlock()
+}
+
+// queue hands steps to the writer, d.mu must be held.
+func (d *Device) queue(steps ...step) {
+	d.steps = append(d.steps, steps...)
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeReplies sends the replies in order, a reply waiting for its delay
+// does not hold up reading the next command.
+func (d *Device) writeReplies() {
+	e := xethru.NewFrameEncoder(d.dev)
+	for {
+		d.mu.Lock()
+		if len(d.steps) == 0 {
+			d.mu.Unlock()
+			select {
+			case <-d.wake:
+				continue
+			case <-d.done:
+				return
+			}
+		}
+		s := d.steps[0]
+		d.steps = d.steps[1:]
+		d.mu.Unlock()
+
+		if s.delay > 0 {
+			select {
+			case <-time.After(s.delay):
+			case <-d.done:
+				return
+			}
+		}
+		if _, err := e.Encode(s.p); err != nil {
+			return
+		}
+	}
+}
+
+// Read returns the bytes the device sent.
+func (d *Device) Read(b []byte) (int, error) {
+	return d.host.Read(b)
+}
+
+// Write takes the bytes of a command.
+func (d *Device) Write(b []byte) (int, error) {
+	return d.host.Write(b)
+}
+
+// SetReadDeadline lets the driver cancel a read.
+func (d *Device) SetReadDeadline(t time.Time) error {
+	return d.host.SetReadDeadline(t)
+}
+
+// SetWriteDeadline lets the driver cancel a write.
+func (d *Device) SetWriteDeadline(t time.Time) error {
+	return d.host.SetWriteDeadline(t)
+}
+
+// Close disconnects the device, the driver then reads io.EOF. Commands
+// written before Close are all accounted for once it returns.
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.host.Close()
+		d.dev.Close()
+	})
+	<-d.read
+	return nil
+}
+
+// Expectation is a command the device waits for and the replies it sends
+// back, its methods add to the replies and may be chained.
+type Expectation struct {
+	cmd []byte
+
+	mu    sync.Mutex
+	delay time.Duration
+	steps []step
+	err   error
+}
+
+// After delays the next reply by d.
+func (e *Expectation) After(d time.Duration) *Expectation {
+	e.mu.Lock()
+	e.delay += d
+	e.mu.Unlock()
+	return e
+}
+
+// Reply sends p as a frame.
+func (e *Expectation) Reply(p []byte) *Expectation {
+	e.mu.Lock()
+	e.steps = append(e.steps, step{delay: e.delay, p: append([]byte(nil), p...)})
+	e.delay = 0
+	e.mu.Unlock()
+	return e
+}
+
+// ReplyAck acknowledges the command.
+func (e *Expectation) ReplyAck() *Expectation {
+	return e.Reply([]byte{ack})
+}
+
+// ReplyError sends an error frame with code, xethru.ProtocolErrorCRCFailed
+// for instance.
+func (e *Expectation) ReplyError(code byte) *Expectation {
+	return e.Reply([]byte{errorByte, code})
+}
+
+// ReplyPing answers a ping with ready or not ready.
+func (e *Expectation) ReplyPing(ready bool) *Expectation {
+	if ready {
+		return e.Reply(pingReply(pingReady))
+	}
+	return e.Reply(pingReply(pingNotReady))
+}
+
+// ReplyBooting and ReplyReady send the system messages of a module that
+// was reset.
+func (e *Expectation) ReplyBooting() *Expectation {
+	return e.Reply([]byte{systemMesg, systemBooting})
+}
+
+// ReplyReady see ReplyBooting.
+func (e *Expectation) ReplyReady() *Expectation {
+	return e.Reply([]byte{systemMesg, systemReady})
+}
+
+// Stream sends each value as a data message: xethru.Respiration,
+// xethru.Sleep, xethru.BaseBandAmpPhase, xethru.BaseBandIQ, one of the X4
+// messages or a []byte payload.
+func (e *Expectation) Stream(v ...interface{}) *Expectation {
+	for _, v := range v {
+		p, err := Payload(v)
+		if err != nil {
+			e.mu.Lock()
+			e.err = err
+			e.mu.Unlock()
+			continue
+		}
+		e.Reply(p)
+	}
+	return e
+}
@@ -0,0 +1,231 @@
+package xethrutest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NeuralSpaz/xethru"
+)
+
+func TestDevice(t *testing.T) {
+	values := []xethru.Respiration{
+		{Counter: 1, RPM: 12, Distance: 0.75, Movement: 0.5, SignalQuality: 10},
+		{Counter: 2, RPM: 13, Distance: 1.25, Movement: -0.5, SignalQuality: 9},
+	}
+	d := NewDevice(t)
+	d.ExpectReset()
+	d.Expect(Load(RespirationApp)).After(50 * time.Millisecond).ReplyAck()
+	d.Expect(SetSensitivity(5)).ReplyAck()
+	d.Expect(Run()).ReplyAck().Stream(values[0], values[1])
+	d.Expect(Stop()).ReplyAck()
+
+	x := xethru.Open("x2m200", d)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ok, err := x.ResetContext(ctx); !ok || err != nil {
+		t.Fatalf("Expected: %v %v, got %v %v\n", true, nil, ok, err)
+	}
+	m := xethru.NewModule(x, "respiration")
+	start := time.Now()
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected: ack after 50ms, got it after %v\n", time.Since(start))
+	}
+	if err := m.SetSensitivityContext(ctx, 5); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+
+	rctx, rcancel := context.WithCancel(ctx)
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- m.RunContext(rctx, stream) }()
+	for n, expected := range values {
+		r := (<-stream).(xethru.Respiration)
+		r.Time, r.Status = 0, expected.Status
+		if !reflect.DeepEqual(r, expected) {
+			t.Errorf("test %d Expected: %+v, got %+v\n", n, expected, r)
+		}
+	}
+	rcancel()
+	<-done
+}
+
+func TestDeviceErrors(t *testing.T) {
+	d := NewDevice(t)
+	// a bad CRC is retried by the driver
+	d.Expect(Load(SleepApp)).ReplyError(xethru.ProtocolErrorCRCFailed)
+	d.Expect(Load(SleepApp)).ReplyAck()
+	d.Expect(SetLEDControl(byte(xethru.LEDFull))).ReplyError(xethru.ProtocolErrorNotRecognised)
+
+	x := xethru.Open("x2m200", d)
+	m := xethru.NewModule(x, "sleep")
+	m.LEDMode = xethru.LEDFull
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.LoadContext(ctx); err != nil {
+		t.Errorf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetLEDModeContext(ctx); !errors.Is(err, xethru.ErrCommandNotRecognised) {
+		t.Errorf("Expected: %v, got %v\n", xethru.ErrCommandNotRecognised, err)
+	}
+}
+
+// recorder stands in for the test so the failures can be checked.
+type recorder struct {
+	testing.TB
+	cleanup []func()
+	errs    []string
+}
+
+func (r *recorder) Cleanup(f func())          { r.cleanup = append(r.cleanup, f) }
+func (r *recorder) Error(args ...interface{}) { r.errs = append(r.errs, fmt.Sprint(args...)) }
+
+func TestDeviceVerify(t *testing.T) {
+	r := &recorder{TB: t}
+	d := NewDevice(r)
+	d.Expect(Load(RespirationApp)).ReplyAck()
+	d.Expect(Run()).ReplyAck()
+
+	x := xethru.Open("x2m200", d)
+	m := xethru.NewModule(x, "sleep")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.LoadContext(ctx); !errors.Is(err, xethru.ErrCommandNotRecognised) {
+		t.Errorf("Expected: %v, got %v\n", xethru.ErrCommandNotRecognised, err)
+	}
+	for _, f := range r.cleanup {
+		f()
+	}
+	if len(r.errs) != 1 {
+		t.Fatalf("Expected: 1 error, got %q\n", r.errs)
+	}
+	for _, s := range []string{
+		"command 0: expected 21 d6 a2 23 14, got 21 17 7b f1 00",
+		"expected command 21 d6 a2 23 14 was not sent",
+		"expected command 20 01 was not sent",
+	} {
+		if !strings.Contains(r.errs[0], s) {
+			t.Errorf("Expected: %q in %q\n", s, r.errs[0])
+		}
+	}
+}
+
+func TestDeviceVerifySettings(t *testing.T) {
+	d := NewDevice(t)
+	d.Expect(GetLEDControl()).Reply(LEDControlReply(byte(xethru.LEDSimple)))
+	d.Expect(GetDetectionZone()).Reply(DetectionZoneReply(0.5, 1.5))
+	d.Expect(GetSensitivity()).Reply(SensitivityReply(3))
+
+	m := xethru.NewModule(xethru.Open("x2m200", d), "respiration")
+	m.LEDMode = xethru.LEDSimple
+	m.DetectionZoneStart, m.DetectionZoneEnd = 0.5, 1.5
+	m.Sensitivity = 5
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := m.VerifyContext(ctx)
+	if !errors.Is(err, xethru.ErrConfigMismatch) || !strings.Contains(err.Error(), "sensitivity 5, module has 3") {
+		t.Errorf("Expected: %v, got %v\n", xethru.ErrConfigMismatch, err)
+	}
+}
+
+func TestDeviceSystemInfo(t *testing.T) {
+	expected := xethru.SystemInfo{Product: "X2M200", ItemNumber: "XTMOD-X2M200", FirmwareID: "XTX2M200", Version: "1.3.4", Build: "release", SerialNumber: "SN0001"}
+	d := NewDevice(t)
+	d.ExpectSystemInfo(expected)
+
+	m := xethru.NewModule(xethru.Open("x2m200", d), "respiration")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if s, err := m.SystemInfoContext(ctx); s != expected || err != nil {
+		t.Errorf("Expected: %+v %v, got %+v %v\n", expected, nil, s, err)
+	}
+}
+
+func TestDeviceSleep(t *testing.T) {
+	values := []xethru.Sleep{
+		{Counter: 1, RPM: 11.5, Distance: 1.25, SignalQuality: 8, MovementSlow: 0.5, MovementFast: 0.25},
+		{Counter: 2, RPM: 12, Distance: 1.5, SignalQuality: 9, MovementSlow: 0.25, MovementFast: 0.125},
+	}
+	d := NewDevice(t)
+	d.Expect(Load(SleepApp)).ReplyAck()
+	d.Expect(SleepOutput(true)).ReplyAck()
+	d.Expect(Run()).ReplyAck().Stream(values[0], values[1])
+	d.Expect(Stop()).ReplyAck()
+
+	m := xethru.NewModule(xethru.Open("x2m200", d), "sleep")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	if err := m.SetSleepOutputContext(ctx, true); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	rctx, rcancel := context.WithCancel(ctx)
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- m.RunContext(rctx, stream) }()
+	for n, expected := range values {
+		s := (<-stream).(xethru.Sleep)
+		s.Time, s.Status = 0, expected.Status
+		if !reflect.DeepEqual(s, expected) {
+			t.Errorf("test %d Expected: %+v, got %+v\n", n, expected, s)
+		}
+	}
+	rcancel()
+	<-done
+}
+
+func TestDeviceX4M300(t *testing.T) {
+	values := []interface{}{
+		xethru.PresenceSingle{Counter: 1, State: 1, Distance: 2.5, Direction: 1, SignalQuality: 7},
+		xethru.PresenceMovingList{Counter: 2, State: 1, MovementSlow: []float64{0.5, 0.25}, MovementFast: []float64{1.5, 1.25}},
+	}
+	d := NewDevice(t)
+	d.Expect(X4Output(0x0c, false)).ReplyAck()
+	d.Expect(X4Output(0x0d, false)).ReplyAck()
+	// no app running yet
+	d.Expect(Stop()).ReplyError(xethru.ProtocolErrorNotRecognised)
+	d.Expect(Reset()).ReplyAck()
+	d.Expect(Load(X4PresenceApp)).ReplyAck()
+	d.Expect(Run()).ReplyAck().Stream(values...)
+	d.Expect(Stop()).ReplyAck()
+
+	x := xethru.Open("x4m300", d)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ok, err := x.ResetContext(ctx); !ok || err != nil {
+		t.Fatalf("Expected: %v %v, got %v %v\n", true, nil, ok, err)
+	}
+	m := xethru.NewModule(x, "presence")
+	if err := m.LoadContext(ctx); err != nil {
+		t.Fatalf("Expected: %v, got %v\n", nil, err)
+	}
+	rctx, rcancel := context.WithCancel(ctx)
+	stream := make(chan interface{})
+	done := make(chan error)
+	go func() { done <- m.RunContext(rctx, stream) }()
+	for n, expected := range values {
+		v := <-stream
+		switch p := v.(type) {
+		case xethru.PresenceSingle:
+			p.Time, p.Status = 0, 0
+			v = p
+		case xethru.PresenceMovingList:
+			p.Time, p.Status = 0, 0
+			v = p
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Errorf("test %d Expected: %+v, got %+v\n", n, expected, v)
+		}
+	}
+	rcancel()
+	<-done
+}